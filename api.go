@@ -0,0 +1,70 @@
+package citadel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrNotFound     = errors.New("resource not found on engine")
+	ErrNotConnected = errors.New("engine is not connected to docker's REST API")
+)
+
+// newRequest builds a raw request against the engine's docker remote API.  It is used
+// for the endpoints that are not covered by dockerclient, mostly streams.
+func (e *Engine) newRequest(method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	if e.client == nil {
+		return nil, ErrNotConnected
+	}
+
+	u := *e.client.URL
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// do executes the request and converts error status codes from docker into errors
+func (e *Engine) do(req *http.Request) (*http.Response, error) {
+	resp, err := e.client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+
+		data, _ := ioutil.ReadAll(resp.Body)
+
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	return resp, nil
+}
+
+func (e *Engine) request(method, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	req, err := e.newRequest(method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.do(req)
+}
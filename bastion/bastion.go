@@ -3,12 +3,23 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/citadel/citadel"
 	"github.com/citadel/citadel/cluster"
+	"github.com/citadel/citadel/crashloop"
+	"github.com/citadel/citadel/eventbus"
+	"github.com/citadel/citadel/eventlog"
+	"github.com/citadel/citadel/gc"
+	"github.com/citadel/citadel/monitor"
 	"github.com/citadel/citadel/scheduler"
+	"github.com/citadel/citadel/webhook"
 	"github.com/gorilla/mux"
 )
 
@@ -16,11 +27,31 @@ var (
 	configPath     string
 	config         *Config
 	clusterManager *cluster.Cluster
+	history        *monitor.History
+	collector      *gc.Collector
+	eventLog       *eventlog.Log
+	bus            *eventbus.EventBus
+	detector       *crashloop.Detector
+
+	execLock      sync.Mutex
+	execExitCodes = make(map[string]*execExitCode)
+)
+
+const (
+	// execExitTTL is how long the exit code of an exec session is kept when it is not fetched
+	execExitTTL = 10 * time.Minute
+
+	// execExitTimeout is how long to wait for an exec process to exit once its output closed
+	execExitTimeout = 30 * time.Second
 )
 
+type execExitCode struct {
+	code   int
+	exited time.Time
+}
+
 func init() {
 	flag.StringVar(&configPath, "conf", "", "config file")
-	flag.Parse()
 }
 
 func destroy(w http.ResponseWriter, r *http.Request) {
@@ -31,12 +62,15 @@ func destroy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := clusterManager.Kill(container, 9); err != nil {
+	signal, err := clusterManager.Stop(container)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 
 		return
 	}
 
+	log.Printf("stopped container %s with %s\n", container.ID, signal)
+
 	if err := clusterManager.Remove(container); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 
@@ -54,7 +88,11 @@ func run(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	container, err := clusterManager.Start(image, false)
+	if history != nil && config.AutoSize {
+		history.ApplyDefaults(image)
+	}
+
+	container, err := clusterManager.Start(image, r.URL.Query().Get("pull") == "true")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 
@@ -85,12 +123,309 @@ func containers(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	if detector != nil {
+		detector.Annotate(containers...)
+	}
+
 	if err := json.NewEncoder(w).Encode(containers); err != nil {
 		log.Println(err)
 	}
 }
 
+func recommendations(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		http.Error(w, "usage history is not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(history.Recommendations()); err != nil {
+		log.Println(err)
+	}
+}
+
+// metadata serves the user data of containers that use the metadata delivery mode
+func metadata(w http.ResponseWriter, r *http.Request) {
+	// the user data is only served for the container's full id
+	container, err := clusterManager.ContainerByID(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if container.Image.UserDataMode() != citadel.UserDataMetadata {
+		http.Error(w, "container does not use metadata user data", http.StatusNotFound)
+		return
+	}
+
+	data, err := clusterManager.UserData(container)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Println(err)
+	}
+}
+
+// pull pre-pulls the image on the engines matching its labels and streams the progress
+func pull(w http.ResponseWriter, r *http.Request) {
+	var image *citadel.Image
+	if err := json.NewDecoder(r.Body).Decode(&image); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+
+	var (
+		lock sync.Mutex
+		enc  = json.NewEncoder(w)
+	)
+
+	results, err := clusterManager.PrePull(image, &scheduler.LabelScheduler{}, func(e *citadel.Engine, p *citadel.PullProgress) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		enc.Encode(&citadel.Event{
+			Type:   citadel.EventPull,
+			Engine: e,
+			Time:   time.Now(),
+			Pull:   p,
+		})
+		flusher.Flush()
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := enc.Encode(results); err != nil {
+		log.Println(err)
+	}
+}
+
+// collect runs the garbage collector once and returns what was removed
+func collect(w http.ResponseWriter, r *http.Request) {
+	if config.GC == nil {
+		http.Error(w, "gc is not enabled", http.StatusNotFound)
+		return
+	}
+
+	p, err := config.GC.policy()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("dry-run") == "true" {
+		p.DryRun = true
+	}
+
+	deletions, err := collector.Collect(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(deletions); err != nil {
+		log.Println(err)
+	}
+}
+
+// logs streams the stdout and stderr of a container, or only one of them with the stream
+// query parameter
+func logs(w http.ResponseWriter, r *http.Request) {
+	container, err := clusterManager.Container(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var (
+		q    = r.URL.Query()
+		opts = &citadel.LogOptions{
+			Follow:     q.Get("follow") == "true",
+			Timestamps: q.Get("timestamps") == "true",
+		}
+	)
+
+	output := q.Get("stream")
+	if output != "" && output != citadel.Stdout && output != citadel.Stderr {
+		http.Error(w, "stream must be stdout or stderr", http.StatusBadRequest)
+		return
+	}
+
+	if tail := q.Get("tail"); tail != "" {
+		if opts.Tail, err = strconv.Atoi(tail); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if since := q.Get("since"); since != "" {
+		ts, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts.Since = time.Unix(ts, 0)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	stream, err := clusterManager.Logs(container, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("content-type", "text/plain")
+
+	for {
+		m, err := stream.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+
+			return
+		}
+
+		if output != "" && m.Stream != output {
+			continue
+		}
+
+		if _, err := w.Write(m.Data); err != nil {
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// exec runs a command in a container over a hijacked connection.  The raw docker stream
+// is sent to the client and the exit code is available from /exec/{id} once it ends.
+func exec(w http.ResponseWriter, r *http.Request) {
+	container, err := clusterManager.Container(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var (
+		q   = r.URL.Query()
+		cmd = q["cmd"]
+		tty = q.Get("tty") == "true"
+	)
+
+	if len(cmd) == 0 {
+		http.Error(w, "no cmd specified", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be hijacked", http.StatusInternalServerError)
+		return
+	}
+
+	session, err := clusterManager.Exec(container, cmd, tty)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer session.Close()
+
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(buf, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\nX-Exec-Id: %s\r\n\r\n", session.ID)
+	if err := buf.Flush(); err != nil {
+		log.Println(err)
+		return
+	}
+
+	go func() {
+		io.Copy(session, buf)
+		session.CloseWrite()
+	}()
+
+	io.Copy(conn, session)
+
+	code, err := session.ExitCode(execExitTimeout)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	storeExecExit(session.ID, code, time.Now())
+}
+
+// storeExecExit keeps the exit code of the session until it is fetched and removes the
+// exit codes that were not fetched within execExitTTL
+func storeExecExit(id string, code int, now time.Time) {
+	execLock.Lock()
+	defer execLock.Unlock()
+
+	for id, c := range execExitCodes {
+		if now.Sub(c.exited) > execExitTTL {
+			delete(execExitCodes, id)
+		}
+	}
+
+	execExitCodes[id] = &execExitCode{code: code, exited: now}
+}
+
+// execExit returns the exit code of an exec session started through bastion
+func execExit(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	execLock.Lock()
+	exit := execExitCodes[id]
+	delete(execExitCodes, id)
+	execLock.Unlock()
+
+	if exit == nil {
+		http.Error(w, "exec session is unknown or still running", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(map[string]int{"exit_code": exit.code}); err != nil {
+		log.Println(err)
+	}
+}
+
 func main() {
+	flag.Parse()
+
 	if err := loadConfig(); err != nil {
 		log.Fatal(err)
 	}
@@ -106,19 +441,151 @@ func main() {
 		}
 	}
 
-	clusterManager, err = cluster.New(scheduler.NewResourceManager(), config.Engines...)
+	var manager citadel.ResourceManager = scheduler.NewResourceManager()
+	if config.MaxLoad > 0 {
+		manager = scheduler.NewLoadResourceManager(config.MaxLoad)
+	}
+
+	clusterManager, err = cluster.New(manager, config.Engines...)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if config.MaxLoad > 0 || config.UsageHistory {
+		collector := monitor.New(10*time.Second, 30)
+		defer collector.Close()
+
+		// the cluster adds its engines to the collector as they join and leave
+		clusterManager.SetUsageMonitor(collector)
+
+		if config.UsageHistory {
+			history = monitor.NewHistory(10000)
+			collector.AddHandler(history)
+		}
+	}
+
+	for _, auth := range config.Registries {
+		clusterManager.AddRegistryAuth(auth)
+	}
+
+	if config.PullConcurrency > 0 {
+		clusterManager.SetPullConcurrency(config.PullConcurrency)
+	}
+
+	for _, image := range config.PrePull {
+		clusterManager.AddPrePullPolicy(image, &scheduler.LabelScheduler{})
+	}
+
+	bus, err = eventbus.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bus.Close()
+
+	bus.SetHistorySize(config.EventHistory)
+
+	// events are published to the bus, through the event log when it is kept so that
+	// they are delivered with their sequence number
+	var publisher citadel.EventHandler = bus
+
+	if config.EventLog != nil {
+		options, err := config.EventLog.options()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		eventLog, err = eventlog.Open(config.EventLog.Dir, options)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer eventLog.Close()
+
+		publisher = eventLog.Then(bus)
+	}
+
+	if err := clusterManager.Events(publisher); err != nil {
+		log.Fatal(err)
+	}
+
+	if config.Webhooks != nil {
+		options, err := config.Webhooks.options()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		webhooks, err := webhook.New(config.Webhooks.Endpoints, options)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer webhooks.Close()
+
+		if err := bus.AddHandler("*", webhooks); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.CrashLoop != nil {
+		p, err := config.CrashLoop.policy()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		detector = crashloop.New(p, publisher)
+
+		if config.CrashLoop.Throttle {
+			detector.Throttle(clusterManager)
+		}
+
+		clusterManager.SetRestartBackoff(detector)
+
+		if _, err := bus.Subscribe(detector, &eventbus.Options{
+			Filter: &eventbus.Filter{
+				Types: []citadel.EventType{citadel.EventDie, citadel.EventOOM, citadel.EventStart, citadel.EventDestroy},
+			},
+		}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	collector = gc.New(clusterManager, publisher)
+
+	if config.GC != nil && config.GC.Interval != "" {
+		p, err := config.GC.policy()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		interval, err := time.ParseDuration(config.GC.Interval)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go collector.Run(p, interval, nil)
+	}
+
+	if config.HealthInterval != "" {
+		interval, err := time.ParseDuration(config.HealthInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go clusterManager.MonitorHealth(interval, nil)
+	}
+
+	if config.PortRange != nil {
+		clusterManager.SetPortAllocator(scheduler.NewPortAllocator(config.PortRange.Start, config.PortRange.End))
+	}
+
 	var (
 		labelScheduler  = &scheduler.LabelScheduler{}
 		uniqueScheduler = &scheduler.UniqueScheduler{}
 		hostScheduler   = &scheduler.HostScheduler{}
+		portScheduler   = &scheduler.PortScheduler{}
 
 		multiScheduler = scheduler.NewMultiScheduler(
 			labelScheduler,
 			uniqueScheduler,
+			portScheduler,
 		)
 	)
 
@@ -126,12 +593,21 @@ func main() {
 	clusterManager.RegisterScheduler("unique", uniqueScheduler)
 	clusterManager.RegisterScheduler("multi", multiScheduler)
 	clusterManager.RegisterScheduler("host", hostScheduler)
+	clusterManager.RegisterScheduler("port", scheduler.NewMultiScheduler(labelScheduler, portScheduler))
 
 	r := mux.NewRouter()
 	r.HandleFunc("/containers", containers).Methods("GET")
 	r.HandleFunc("/run", run).Methods("POST")
 	r.HandleFunc("/destroy", destroy).Methods("DELETE")
 	r.HandleFunc("/engines", engines).Methods("GET")
+	r.HandleFunc("/recommendations", recommendations).Methods("GET")
+	r.HandleFunc("/metadata/{id}", metadata).Methods("GET")
+	r.HandleFunc("/pull", pull).Methods("POST")
+	r.HandleFunc("/gc", collect).Methods("POST")
+	r.HandleFunc("/containers/{id}/logs", logs).Methods("GET")
+	r.HandleFunc("/containers/{id}/exec", exec).Methods("POST")
+	r.HandleFunc("/exec/{id}", execExit).Methods("GET")
+	r.HandleFunc("/events", events).Methods("GET")
 
 	log.Printf("bastion listening on %s\n", config.ListenAddr)
 
@@ -3,16 +3,184 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"time"
 
 	"github.com/citadel/citadel"
+	"github.com/citadel/citadel/crashloop"
+	"github.com/citadel/citadel/eventlog"
+	"github.com/citadel/citadel/gc"
+	"github.com/citadel/citadel/webhook"
 )
 
 type Config struct {
-	SSLCertificate string            `json:"ssl-cert,omitempty"`
-	SSLKey         string            `json:"ssl-key,omitempty"`
-	CACertificate  string            `json:"ca-cert,omitempty"`
-	ListenAddr     string            `json:"listen-addr,omitempty"`
-	Engines        []*citadel.Engine `json:"engines,omitempty"`
+	SSLCertificate  string                  `json:"ssl-cert,omitempty"`
+	SSLKey          string                  `json:"ssl-key,omitempty"`
+	CACertificate   string                  `json:"ca-cert,omitempty"`
+	ListenAddr      string                  `json:"listen-addr,omitempty"`
+	MaxLoad         float64                 `json:"max-load,omitempty"`
+	UsageHistory    bool                    `json:"usage-history,omitempty"`
+	AutoSize        bool                    `json:"auto-size,omitempty"`
+	PortRange       *PortRange              `json:"port-range,omitempty"`
+	Registries      []*citadel.RegistryAuth `json:"registries,omitempty"`
+	PullConcurrency int                     `json:"pull-concurrency,omitempty"`
+	PrePull         []*citadel.Image        `json:"pre-pull,omitempty"`
+	GC              *GCConfig               `json:"gc,omitempty"`
+	EventLog        *EventLogConfig         `json:"event-log,omitempty"`
+	EventHistory    int                     `json:"event-history,omitempty"`
+	HealthInterval  string                  `json:"health-interval,omitempty"`
+	Webhooks        *WebhookConfig          `json:"webhooks,omitempty"`
+	CrashLoop       *CrashLoopConfig        `json:"crash-loop,omitempty"`
+	Engines         []*citadel.Engine       `json:"engines,omitempty"`
+}
+
+type PortRange struct {
+	Start int `json:"start,omitempty"`
+	End   int `json:"end,omitempty"`
+}
+
+type GCConfig struct {
+	KeepExited int    `json:"keep-exited,omitempty"`
+	ExitedTTL  string `json:"exited-ttl,omitempty"`
+	ImageTTL   string `json:"image-ttl,omitempty"`
+	Interval   string `json:"interval,omitempty"`
+	DryRun     bool   `json:"dry-run,omitempty"`
+}
+
+type EventLogConfig struct {
+	Dir         string `json:"dir,omitempty"`
+	SegmentSize int64  `json:"segment-size,omitempty"`
+	MaxSegments int    `json:"max-segments,omitempty"`
+	MaxAge      string `json:"max-age,omitempty"`
+	Sync        bool   `json:"sync,omitempty"`
+}
+
+func (c *EventLogConfig) options() (eventlog.Options, error) {
+	o := eventlog.Options{
+		SegmentSize: c.SegmentSize,
+		MaxSegments: c.MaxSegments,
+		Sync:        c.Sync,
+	}
+
+	if c.MaxAge != "" {
+		d, err := time.ParseDuration(c.MaxAge)
+		if err != nil {
+			return o, err
+		}
+		o.MaxAge = d
+	}
+
+	return o, nil
+}
+
+type WebhookConfig struct {
+	Endpoints     []*webhook.Endpoint `json:"endpoints,omitempty"`
+	BatchSize     int                 `json:"batch-size,omitempty"`
+	FlushInterval string              `json:"flush-interval,omitempty"`
+	Attempts      int                 `json:"attempts,omitempty"`
+	Backoff       string              `json:"backoff,omitempty"`
+	MaxBackoff    string              `json:"max-backoff,omitempty"`
+	SpoolDir      string              `json:"spool-dir,omitempty"`
+}
+
+func (c *WebhookConfig) options() (webhook.Options, error) {
+	o := webhook.Options{
+		BatchSize: c.BatchSize,
+		Attempts:  c.Attempts,
+		SpoolDir:  c.SpoolDir,
+	}
+
+	if c.FlushInterval != "" {
+		d, err := time.ParseDuration(c.FlushInterval)
+		if err != nil {
+			return o, err
+		}
+		o.FlushInterval = d
+	}
+
+	if c.Backoff != "" {
+		d, err := time.ParseDuration(c.Backoff)
+		if err != nil {
+			return o, err
+		}
+		o.Backoff = d
+	}
+
+	if c.MaxBackoff != "" {
+		d, err := time.ParseDuration(c.MaxBackoff)
+		if err != nil {
+			return o, err
+		}
+		o.MaxBackoff = d
+	}
+
+	return o, nil
+}
+
+type CrashLoopConfig struct {
+	Window             string `json:"window,omitempty"`
+	MaxRestarts        int    `json:"max-restarts,omitempty"`
+	MaxServiceRestarts int    `json:"max-service-restarts,omitempty"`
+	Backoff            string `json:"backoff,omitempty"`
+	MaxBackoff         string `json:"max-backoff,omitempty"`
+	Throttle           bool   `json:"throttle,omitempty"`
+}
+
+func (c *CrashLoopConfig) policy() (crashloop.Policy, error) {
+	p := crashloop.Policy{
+		MaxRestarts:        c.MaxRestarts,
+		MaxServiceRestarts: c.MaxServiceRestarts,
+	}
+
+	if c.Window != "" {
+		d, err := time.ParseDuration(c.Window)
+		if err != nil {
+			return p, err
+		}
+		p.Window = d
+	}
+
+	if c.Backoff != "" {
+		d, err := time.ParseDuration(c.Backoff)
+		if err != nil {
+			return p, err
+		}
+		p.Backoff = d
+	}
+
+	if c.MaxBackoff != "" {
+		d, err := time.ParseDuration(c.MaxBackoff)
+		if err != nil {
+			return p, err
+		}
+		p.MaxBackoff = d
+	}
+
+	return p, nil
+}
+
+func (c *GCConfig) policy() (gc.Policy, error) {
+	p := gc.Policy{
+		KeepExited: c.KeepExited,
+		DryRun:     c.DryRun,
+	}
+
+	if c.ExitedTTL != "" {
+		d, err := time.ParseDuration(c.ExitedTTL)
+		if err != nil {
+			return p, err
+		}
+		p.ExitedTTL = d
+	}
+
+	if c.ImageTTL != "" {
+		d, err := time.ParseDuration(c.ImageTTL)
+		if err != nil {
+			return p, err
+		}
+		p.ImageTTL = d
+	}
+
+	return p, nil
 }
 
 func loadConfig() error {
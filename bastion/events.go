@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/citadel/citadel"
+	"github.com/citadel/citadel/eventbus"
+	"github.com/citadel/citadel/eventlog"
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// eventStream queues the events of a subscription for a client connection
+type eventStream struct {
+	events chan *citadel.Event
+	done   chan struct{}
+
+	// filter selects the events of the event log, events from the bus are already filtered
+	filter *eventbus.Filter
+}
+
+func (s *eventStream) Handle(e *citadel.Event) error {
+	if s.filter != nil && !s.filter.Match(e, "") {
+		return nil
+	}
+
+	select {
+	case s.events <- e.Record():
+	case <-s.done:
+	}
+
+	return nil
+}
+
+// events streams the cluster's events to the client as server-sent events, or as json
+// messages when the request is a websocket upgrade
+func events(w http.ResponseWriter, r *http.Request) {
+	opts, err := eventOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !opts.Since.IsZero() && eventLog == nil && config.EventHistory <= 0 {
+		http.Error(w, "since requires event-history or event-log to be set in the config", http.StatusBadRequest)
+		return
+	}
+
+	stream := &eventStream{
+		events: make(chan *citadel.Event, eventbus.DefaultQueueSize),
+		done:   make(chan struct{}),
+	}
+
+	unsubscribe, err := subscribe(stream, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer unsubscribe()
+
+	// the stream is closed first so that a handler blocked on it returns before unsubscribing
+	defer close(stream.done)
+
+	if websocket.IsWebSocketUpgrade(r) {
+		streamWebsocket(w, r, stream)
+		return
+	}
+
+	streamSSE(w, r, stream)
+}
+
+// subscribe sends the events to the stream from the event log when the stream replays past
+// events and the log is kept, otherwise from the bus.  It returns the func that ends the
+// subscription.
+func subscribe(stream *eventStream, opts *eventbus.Options) (func(), error) {
+	if !opts.Since.IsZero() && eventLog != nil {
+		stream.filter = opts.Filter
+
+		subscription := eventLog.Subscribe(eventlog.Position{Time: opts.Since}, stream)
+
+		return subscription.Close, nil
+	}
+
+	subscription, err := bus.Subscribe(stream, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { subscription.Unsubscribe() }, nil
+}
+
+func streamSSE(w http.ResponseWriter, r *http.Request, stream *eventStream) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-stream.events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("bastion: unable to encode %s event: %s\n", e.Type, err)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func streamWebsocket(w http.ResponseWriter, r *http.Request, stream *eventStream) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("bastion: unable to upgrade events request: %s\n", err)
+		return
+	}
+	defer conn.Close()
+
+	// the client does not send messages, reading detects when the connection is closed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case e := <-stream.events:
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// eventOptions builds the subscription for the query's type, engine and image filters
+// and the since time to replay events from
+func eventOptions(r *http.Request) (*eventbus.Options, error) {
+	var (
+		q    = r.URL.Query()
+		opts = &eventbus.Options{
+			Overflow: eventbus.DropOldest,
+			Filter: &eventbus.Filter{
+				Engines: queryValues(q["engine"]),
+				Images:  queryValues(q["image"]),
+			},
+		}
+	)
+
+	for _, t := range queryValues(q["type"]) {
+		opts.Filter.Types = append(opts.Filter.Types, citadel.EventType(t))
+	}
+
+	if since := q.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Since = t
+	}
+
+	return opts, nil
+}
+
+// queryValues splits comma separated query values
+func queryValues(values []string) []string {
+	out := []string{}
+
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+
+	return out
+}
+
+// parseSince parses a unix timestamp or an RFC 3339 time
+func parseSince(since string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return t, fmt.Errorf("since must be a unix timestamp or an RFC 3339 time: %s", since)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/citadel/citadel"
+	"github.com/citadel/citadel/eventbus"
+	"github.com/citadel/citadel/eventlog"
+)
+
+func setupEvents(t *testing.T, c *Config) {
+	var err error
+
+	config = c
+	eventLog = nil
+
+	bus, err = eventbus.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readEvent returns the type and event of the next server-sent event of the stream
+func readEvent(t *testing.T, r *bufio.Reader) (string, *citadel.Event) {
+	var (
+		tpe   string
+		event *citadel.Event
+	)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			tpe = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && event != nil:
+			return tpe, event
+		}
+	}
+}
+
+func TestEventsFiltersStream(t *testing.T) {
+	setupEvents(t, &Config{})
+	defer bus.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(events))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?type=die,oom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("content-type"); ct != "text/event-stream" {
+		t.Fatalf("expected content type text/event-stream received %s", ct)
+	}
+
+	container := &citadel.Container{ID: "1", Image: &citadel.Image{Name: "crosbymichael/redis"}}
+
+	bus.Handle(&citadel.Event{Type: citadel.EventStart, Container: container, Time: time.Now()})
+	bus.Handle(&citadel.Event{Type: citadel.EventDie, Container: container, Time: time.Now()})
+
+	tpe, e := readEvent(t, bufio.NewReader(resp.Body))
+	if tpe != string(citadel.EventDie) || e.Type != citadel.EventDie || e.Container.ID != "1" {
+		t.Fatalf("expected the die event of container 1 received %s %+v", tpe, e)
+	}
+}
+
+func TestEventsReplaysEventLog(t *testing.T) {
+	setupEvents(t, &Config{})
+	defer bus.Close()
+
+	dir, err := ioutil.TempDir("", "bastion-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	eventLog, err = eventlog.Open(dir, eventlog.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eventLog.Close()
+
+	var (
+		start     = time.Now().Add(-time.Hour)
+		container = &citadel.Container{ID: "1", Image: &citadel.Image{Name: "crosbymichael/redis"}}
+	)
+
+	for i, tpe := range []citadel.EventType{citadel.EventDie, citadel.EventStart, citadel.EventDie} {
+		if err := eventLog.Handle(&citadel.Event{Type: tpe, Container: container, Time: start.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(events))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?type=die&since=" + start.Add(30*time.Second).Format(time.RFC3339))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	_, e := readEvent(t, bufio.NewReader(resp.Body))
+	if e.Type != citadel.EventDie || e.Sequence != 3 {
+		t.Fatalf("expected the die event with sequence 3 to be replayed received %s %d", e.Type, e.Sequence)
+	}
+}
+
+func TestEventsSinceRequiresHistory(t *testing.T) {
+	setupEvents(t, &Config{})
+	defer bus.Close()
+
+	w := httptest.NewRecorder()
+	events(w, httptest.NewRequest("GET", "/events?since=0", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d received %d", http.StatusBadRequest, w.Code)
+	}
+}
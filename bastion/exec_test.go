@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStoreExecExitRemovesStaleCodes(t *testing.T) {
+	now := time.Now()
+
+	storeExecExit("old", 1, now.Add(-2*execExitTTL))
+	storeExecExit("new", 0, now)
+
+	execLock.Lock()
+	defer execLock.Unlock()
+
+	if _, exists := execExitCodes["old"]; exists {
+		t.Fatal("expected the stale exit code to be removed")
+	}
+
+	if c := execExitCodes["new"]; c == nil || c.code != 0 {
+		t.Fatalf("expected the new exit code to be kept received %+v", c)
+	}
+}
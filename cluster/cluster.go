@@ -1,23 +1,42 @@
 package cluster
 
 import (
-	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/citadel/citadel"
 )
 
 var (
-	ErrEngineNotConnected = errors.New("engine is not connected to docker's REST API")
+	ErrEngineNotConnected = citadel.ErrNotConnected
 )
 
+// engineMonitor is implemented by usage monitors that sample engines as they join and
+// leave the cluster
+type engineMonitor interface {
+	AddEngine(*citadel.Engine)
+	RemoveEngine(*citadel.Engine)
+}
+
 type Cluster struct {
 	mux sync.Mutex
 
 	engines         map[string]*citadel.Engine
 	schedulers      map[string]citadel.Scheduler
 	resourceManager citadel.ResourceManager
+	usageMonitor    citadel.UsageMonitor
+	portAllocator   citadel.PortAllocator
+	registryAuth    []*citadel.RegistryAuth
+	pullConcurrency int
+	prePullPolicies []*prePullPolicy
+	eventHandlers   []citadel.EventHandler
+	restartBackoff  citadel.RestartBackoff
+
+	// unhealthy are the engines that failed their last health check with the reason
+	unhealthy map[string]string
 }
 
 func New(manager citadel.ResourceManager, engines ...*citadel.Engine) (*Cluster, error) {
@@ -25,6 +44,7 @@ func New(manager citadel.ResourceManager, engines ...*citadel.Engine) (*Cluster,
 		engines:         make(map[string]*citadel.Engine),
 		schedulers:      make(map[string]citadel.Scheduler),
 		resourceManager: manager,
+		unhealthy:       make(map[string]string),
 	}
 
 	for _, e := range engines {
@@ -38,16 +58,67 @@ func New(manager citadel.ResourceManager, engines ...*citadel.Engine) (*Cluster,
 	return c, nil
 }
 
+// Events adds a handler for the docker events of the engines and the events of the
+// cluster.  Engines added to the cluster later send their events to the handler too.
 func (c *Cluster) Events(handler citadel.EventHandler) error {
+	// a handler that cannot be compared could never be removed
+	if !citadel.SameHandler(handler, handler) {
+		return fmt.Errorf("event handler %T cannot be compared, use a pointer", handler)
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, h := range c.eventHandlers {
+		if citadel.SameHandler(h, handler) {
+			return fmt.Errorf("event handler already added")
+		}
+	}
+
+	attached := []*citadel.Engine{}
+
 	for _, e := range c.engines {
 		if err := e.Events(handler); err != nil {
+			// the handler is only added if it is added to every engine
+			for _, a := range attached {
+				if err := a.RemoveEventHandler(handler); err != nil {
+					log.Printf("cluster: unable to remove event handler from %s: %s\n", a, err)
+				}
+			}
+
 			return err
 		}
+
+		attached = append(attached, e)
 	}
 
+	c.eventHandlers = append(c.eventHandlers, handler)
+
 	return nil
 }
 
+// RemoveEventHandler stops sending the events of the cluster and its engines to the handler
+func (c *Cluster) RemoveEventHandler(handler citadel.EventHandler) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for i, h := range c.eventHandlers {
+		if citadel.SameHandler(h, handler) {
+			c.eventHandlers = append(c.eventHandlers[:i], c.eventHandlers[i+1:]...)
+
+			for _, e := range c.engines {
+				if err := e.RemoveEventHandler(handler); err != nil {
+					log.Printf("cluster: unable to remove event handler from %s: %s\n", e, err)
+				}
+			}
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("event handler is not added to the cluster")
+}
+
 func (c *Cluster) RegisterScheduler(tpe string, s citadel.Scheduler) error {
 	c.mux.Lock()
 	defer c.mux.Unlock()
@@ -57,24 +128,131 @@ func (c *Cluster) RegisterScheduler(tpe string, s citadel.Scheduler) error {
 	return nil
 }
 
-func (c *Cluster) AddEngine(e *citadel.Engine) error {
+// SetUsageMonitor sets the monitor used to report the live resource usage of the
+// engines to the resource manager.  Monitors that sample engines are given the engines
+// of the cluster as they are added and removed.
+func (c *Cluster) SetUsageMonitor(m citadel.UsageMonitor) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.usageMonitor = m
+
+	if em, ok := m.(engineMonitor); ok {
+		for _, e := range c.engines {
+			em.AddEngine(e)
+		}
+	}
+}
+
+// SetPortAllocator sets the allocator used to assign host ports to the bind ports of
+// images that do not request a specific host port
+func (c *Cluster) SetPortAllocator(a citadel.PortAllocator) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
+	c.portAllocator = a
+}
+
+// SetRestartBackoff sets what holds back the rescheduling of containers that restart too often
+func (c *Cluster) SetRestartBackoff(b citadel.RestartBackoff) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.restartBackoff = b
+}
+
+// AddRegistryAuth adds credentials used by all engines to pull images from the registry.
+// Credentials configured on an engine take precedence over the cluster's.
+func (c *Cluster) AddRegistryAuth(auth *citadel.RegistryAuth) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.registryAuth = append(c.registryAuth, auth)
+}
+
+// registryAuthFor returns the credentials for the engine to pull the image
+func (c *Cluster) registryAuthFor(e *citadel.Engine, image string) *citadel.RegistryAuth {
+	if auth := e.RegistryAuthFor(image); auth != nil {
+		return auth
+	}
+
+	return citadel.RegistryAuthFor(c.registryAuth, image)
+}
+
+// AddEngine adds the engine to the cluster and attaches the cluster's event handlers to it
+func (c *Cluster) AddEngine(e *citadel.Engine) error {
+	c.mux.Lock()
+	if existing := c.engines[e.ID]; existing != nil && existing != e {
+		c.detachEvents(existing)
+	}
+
+	for i, h := range c.eventHandlers {
+		if err := e.Events(h); err != nil {
+			// the engine is not added so the handlers attached before are removed
+			for _, attached := range c.eventHandlers[:i] {
+				if err := e.RemoveEventHandler(attached); err != nil {
+					log.Printf("cluster: unable to remove event handler from %s: %s\n", e, err)
+				}
+			}
+
+			c.mux.Unlock()
+			return err
+		}
+	}
+
 	c.engines[e.ID] = e
 
+	if em, ok := c.usageMonitor.(engineMonitor); ok {
+		em.AddEngine(e)
+	}
+
+	if len(c.prePullPolicies) > 0 {
+		go c.applyPrePullPolicies(e, c.prePullPolicies)
+	}
+	c.mux.Unlock()
+
+	c.publish(&citadel.Event{
+		Type:   citadel.EventEngineAdded,
+		Engine: e,
+		Time:   time.Now(),
+	})
+
 	return nil
 }
 
+// RemoveEngine removes the engine from the cluster and detaches the cluster's event handlers
 func (c *Cluster) RemoveEngine(e *citadel.Engine) error {
 	c.mux.Lock()
-	defer c.mux.Unlock()
+	if existing := c.engines[e.ID]; existing != nil {
+		c.detachEvents(existing)
+	}
+
+	if em, ok := c.usageMonitor.(engineMonitor); ok {
+		em.RemoveEngine(e)
+	}
 
 	delete(c.engines, e.ID)
+	delete(c.unhealthy, e.ID)
+	c.mux.Unlock()
+
+	c.publish(&citadel.Event{
+		Type:   citadel.EventEngineRemoved,
+		Engine: e,
+		Time:   time.Now(),
+	})
 
 	return nil
 }
 
+// detachEvents removes the cluster's event handlers from the engine
+func (c *Cluster) detachEvents(e *citadel.Engine) {
+	for _, h := range c.eventHandlers {
+		if err := e.RemoveEventHandler(h); err != nil {
+			log.Printf("cluster: unable to remove event handler from %s: %s\n", e, err)
+		}
+	}
+}
+
 // ListContainers returns all the containers running in the cluster
 func (c *Cluster) ListContainers(all bool) ([]*citadel.Container, error) {
 	c.mux.Lock()
@@ -94,6 +272,64 @@ func (c *Cluster) ListContainers(all bool) ([]*citadel.Container, error) {
 	return out, nil
 }
 
+// Container returns the container in the cluster with the id, name or a unique id prefix
+func (c *Cluster) Container(id string) (*citadel.Container, error) {
+	containers, err := c.ListContainers(true)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []*citadel.Container{}
+
+	for _, container := range containers {
+		if container.ID == id || strings.TrimPrefix(container.Name, "/") == id {
+			return container, nil
+		}
+
+		if strings.HasPrefix(container.ID, id) {
+			matches = append(matches, container)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("container %s is not in cluster", id)
+	case 1:
+		return matches[0], nil
+	}
+
+	return nil, fmt.Errorf("container id prefix %s matches %d containers", id, len(matches))
+}
+
+// ContainerByID returns the container in the cluster with exactly the id
+func (c *Cluster) ContainerByID(id string) (*citadel.Container, error) {
+	containers, err := c.ListContainers(true)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, container := range containers {
+		if container.ID == id {
+			return container, nil
+		}
+	}
+
+	return nil, fmt.Errorf("container %s is not in cluster", id)
+}
+
+// UserData returns the user data delivered to the container by the engine that it runs on
+func (c *Cluster) UserData(container *citadel.Container) (map[string][]string, error) {
+	c.mux.Lock()
+	engine := c.engines[container.Engine.ID]
+	c.mux.Unlock()
+
+	if engine == nil {
+		return nil, fmt.Errorf("engine with id %s is not in cluster", container.Engine.ID)
+	}
+
+	return engine.UserData(container)
+}
+
 func (c *Cluster) Kill(container *citadel.Container, sig int) error {
 	c.mux.Lock()
 	defer c.mux.Unlock()
@@ -106,16 +342,19 @@ func (c *Cluster) Kill(container *citadel.Container, sig int) error {
 	return engine.Kill(container, sig)
 }
 
-func (c *Cluster) Stop(container *citadel.Container) error {
+// Stop sends the image's stop signal to the container and escalates to SIGKILL if the
+// container has not exited after the image's stop timeout.  It returns the signal that
+// ended the container.
+func (c *Cluster) Stop(container *citadel.Container) (string, error) {
 	c.mux.Lock()
-	defer c.mux.Unlock()
-
 	engine := c.engines[container.Engine.ID]
+	c.mux.Unlock()
+
 	if engine == nil {
-		return fmt.Errorf("engine with id %s is not in cluster", container.Engine.ID)
+		return "", fmt.Errorf("engine with id %s is not in cluster", container.Engine.ID)
 	}
 
-	return engine.Stop(container)
+	return engine.GracefulStop(container)
 }
 
 func (c *Cluster) Restart(container *citadel.Container, timeout int) error {
@@ -142,49 +381,194 @@ func (c *Cluster) Remove(container *citadel.Container) error {
 	return engine.Remove(container)
 }
 
+// Logs returns the log stream of the container from the engine that it runs on
+func (c *Cluster) Logs(container *citadel.Container, opts *citadel.LogOptions) (*citadel.LogStream, error) {
+	c.mux.Lock()
+	engine := c.engines[container.Engine.ID]
+	c.mux.Unlock()
+
+	if engine == nil {
+		return nil, fmt.Errorf("engine with id %s is not in cluster", container.Engine.ID)
+	}
+
+	return engine.Logs(container, opts)
+}
+
+// Exec starts the command inside of the container on the engine that it runs on
+func (c *Cluster) Exec(container *citadel.Container, cmd []string, tty bool) (*citadel.ExecSession, error) {
+	c.mux.Lock()
+	engine := c.engines[container.Engine.ID]
+	c.mux.Unlock()
+
+	if engine == nil {
+		return nil, fmt.Errorf("engine with id %s is not in cluster", container.Engine.ID)
+	}
+
+	return engine.Exec(container, cmd, tty)
+}
+
 func (c *Cluster) Start(image *citadel.Image, pull bool) (*citadel.Container, error) {
 	c.mux.Lock()
-	defer c.mux.Unlock()
+	container, schedule, err := c.start(image, pull, nil)
+	c.mux.Unlock()
+
+	c.publishSchedule(image, container, schedule, err)
+
+	return container, err
+}
+
+// Reschedule starts a new container for the container's image on another engine and then
+// stops and removes the container.  The reason is recorded in the rescheduled event.  An
+// error is returned while the container's restarts are backed off.
+func (c *Cluster) Reschedule(container *citadel.Container, reason string, pull bool) (*citadel.Container, error) {
+	image := container.Image
+
+	// user data that is not stored with the container is read back for the replacement
+	if image.UserData == nil && image.UserDataMode() != citadel.UserDataLabels {
+		data, err := c.UserData(container)
+		if err != nil {
+			return nil, err
+		}
+
+		copied := *image
+		copied.UserData = data
+		image = &copied
+	}
+
+	c.mux.Lock()
+	if c.restartBackoff != nil {
+		if wait := c.restartBackoff.Backoff(container); wait > 0 {
+			c.mux.Unlock()
+			return nil, fmt.Errorf("rescheduling container %s is backed off for %s", container.ID, wait)
+		}
+	}
+
+	replacement, schedule, err := c.start(image, pull, container.Engine)
+	c.mux.Unlock()
+
+	c.publishSchedule(image, replacement, schedule, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.retire(container); err != nil {
+		return replacement, err
+	}
 
+	c.publish(&citadel.Event{
+		Type:      citadel.EventRescheduled,
+		Container: replacement,
+		Engine:    replacement.Engine,
+		Previous:  container,
+		Reason:    reason,
+		Time:      time.Now(),
+	})
+
+	return replacement, nil
+}
+
+// Preempt stops and removes the container to make room for other containers.  The reason
+// is recorded in the preempted event.
+func (c *Cluster) Preempt(container *citadel.Container, reason string) error {
+	if err := c.retire(container); err != nil {
+		return err
+	}
+
+	c.publish(&citadel.Event{
+		Type:      citadel.EventPreempted,
+		Container: container,
+		Engine:    container.Engine,
+		Reason:    reason,
+		Time:      time.Now(),
+	})
+
+	return nil
+}
+
+// retire stops the container gracefully if it is running and removes it
+func (c *Cluster) retire(container *citadel.Container) error {
+	if container.State == "running" {
+		if _, err := c.Stop(container); err != nil && err != citadel.ErrNotFound {
+			return err
+		}
+	}
+
+	if err := c.Remove(container); err != nil && err != citadel.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// start places the image on an engine, other than exclude, and starts a container for it.
+// The scheduling decision is returned even when the image could not be placed.
+func (c *Cluster) start(image *citadel.Image, pull bool, exclude *citadel.Engine) (*citadel.Container, *citadel.Schedule, error) {
 	var (
 		accepted  = []*citadel.EngineSnapshot{}
 		scheduler = c.schedulers[image.Type]
+		schedule  = &citadel.Schedule{
+			Rejected: make(map[string]string),
+		}
 	)
 
 	if scheduler == nil {
-		return nil, fmt.Errorf("no scheduler for type %s", image.Type)
+		return nil, schedule, fmt.Errorf("no scheduler for type %s", image.Type)
 	}
 
-	for _, e := range c.engines {
+	engines, err := c.volumeEngines(image, schedule)
+	if err != nil {
+		return nil, schedule, err
+	}
+
+	for _, e := range engines {
+		if exclude != nil && e.ID == exclude.ID {
+			schedule.Rejected[e.ID] = "container is being moved off of the engine"
+			continue
+		}
+
 		canrun, err := scheduler.Schedule(image, e)
 		if err != nil {
-			return nil, err
+			return nil, schedule, err
 		}
 
-		if canrun {
-			containers, err := e.ListContainers(false)
-			if err != nil {
-				return nil, err
-			}
+		if !canrun {
+			schedule.Rejected[e.ID] = fmt.Sprintf("rejected by %s scheduler", image.Type)
+			continue
+		}
 
-			var cpus, memory float64
-			for _, con := range containers {
-				cpus += con.Image.Cpus
-				memory += con.Image.Memory
-			}
+		containers, err := e.ListContainers(false)
+		if err != nil {
+			return nil, schedule, err
+		}
 
-			accepted = append(accepted, &citadel.EngineSnapshot{
-				ID:             e.ID,
-				ReservedCpus:   cpus,
-				ReservedMemory: memory,
-				Cpus:           e.Cpus,
-				Memory:         e.Memory,
-			})
+		var cpus, memory, disk float64
+		for _, con := range containers {
+			cpus += con.Image.Cpus
+			memory += con.Image.Memory
+			disk += con.Image.Disk
 		}
+
+		snapshot := &citadel.EngineSnapshot{
+			ID:             e.ID,
+			ReservedCpus:   cpus,
+			ReservedMemory: memory,
+			ReservedDisk:   disk,
+			Cpus:           e.Cpus,
+			Memory:         e.Memory,
+			Disk:           e.Disk,
+		}
+
+		if c.usageMonitor != nil {
+			snapshot.CurrentCpu, snapshot.CurrentMemory = c.usageMonitor.Usage(e)
+		}
+
+		accepted = append(accepted, snapshot)
+		schedule.Accepted = append(schedule.Accepted, e.ID)
 	}
 
 	if len(accepted) == 0 {
-		return nil, fmt.Errorf("no eligible engines to run image")
+		return nil, schedule, fmt.Errorf("no eligible engines to run image")
 	}
 
 	container := &citadel.Container{
@@ -194,16 +578,106 @@ func (c *Cluster) Start(image *citadel.Image, pull bool) (*citadel.Container, er
 
 	s, err := c.resourceManager.PlaceContainer(container, accepted)
 	if err != nil {
-		return nil, err
+		return nil, schedule, err
 	}
 
+	schedule.Engine = s.ID
 	engine := c.engines[s.ID]
 
-	if err := engine.Start(container, pull); err != nil {
-		return nil, err
+	if c.portAllocator != nil && len(image.BindPorts) > 0 {
+		ports, err := c.portAllocator.AllocatePorts(image, engine)
+		if err != nil {
+			return nil, schedule, err
+		}
+
+		// the image keeps its dynamic ports so that the stored spec is the one submitted
+		container.Ports = ports
+	}
+
+	if pull {
+		if err := engine.Pull(image.Name, c.registryAuthFor(engine, image.Name), nil); err != nil {
+			return nil, schedule, err
+		}
+	}
+
+	if err := engine.Start(container, false); err != nil {
+		return nil, schedule, err
 	}
 
-	return container, nil
+	return container, schedule, nil
+}
+
+// volumeEngines returns the healthy engines that are able to run the image based on its
+// named volumes.  If the volumes already exist in the cluster only the engines holding them
+// are returned so that the container is placed next to its data.  Unhealthy engines and
+// engines that are unable to list their volumes are rejected in the schedule.
+func (c *Cluster) volumeEngines(image *citadel.Image, schedule *citadel.Schedule) ([]*citadel.Engine, error) {
+	var (
+		all   = []*citadel.Engine{}
+		names = make(map[string]bool)
+	)
+
+	for _, e := range c.engines {
+		if reason, unhealthy := c.unhealthy[e.ID]; unhealthy {
+			schedule.Rejected[e.ID] = fmt.Sprintf("engine is unhealthy: %s", reason)
+			continue
+		}
+
+		all = append(all, e)
+	}
+
+	for _, v := range image.Volumes {
+		if v.Name != "" {
+			names[v.Name] = true
+		}
+	}
+
+	if len(names) == 0 {
+		return all, nil
+	}
+
+	var (
+		found   = make(map[string]bool)
+		holding = make(map[*citadel.Engine]map[string]bool)
+		listed  = []*citadel.Engine{}
+	)
+
+	for _, e := range all {
+		volumes, err := e.ListVolumes()
+		if err != nil {
+			log.Printf("cluster: unable to list volumes on %s: %s\n", e.ID, err)
+			schedule.Rejected[e.ID] = fmt.Sprintf("unable to list volumes: %s", err)
+			continue
+		}
+
+		listed = append(listed, e)
+		holding[e] = make(map[string]bool)
+
+		for _, v := range volumes {
+			if names[v] {
+				found[v] = true
+				holding[e][v] = true
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		return listed, nil
+	}
+
+	// only engines that hold every volume that already exists in the cluster are eligible
+	out := []*citadel.Engine{}
+	for _, e := range listed {
+		if len(holding[e]) == len(found) {
+			out = append(out, e)
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("named volumes for %s are spread across multiple engines", image.Name)
+	}
+
+	return out, nil
 }
 
 // Engines returns the engines registered in the cluster
@@ -230,8 +704,10 @@ func (c *Cluster) ClusterInfo() (*citadel.ClusterInfo, error) {
 	engineCount := len(c.engines)
 	totalCpu := 0.0
 	totalMemory := 0.0
+	totalDisk := 0.0
 	reservedCpus := 0.0
 	reservedMemory := 0.0
+	reservedDisk := 0.0
 	for _, e := range c.engines {
 		c, err := e.ListContainers(false)
 		if err != nil {
@@ -240,6 +716,7 @@ func (c *Cluster) ClusterInfo() (*citadel.ClusterInfo, error) {
 		for _, cnt := range c {
 			reservedCpus += cnt.Image.Cpus
 			reservedMemory += cnt.Image.Memory
+			reservedDisk += cnt.Image.Disk
 		}
 		i, err := e.ListImages()
 		if err != nil {
@@ -249,16 +726,19 @@ func (c *Cluster) ClusterInfo() (*citadel.ClusterInfo, error) {
 		imageCount += len(i)
 		totalCpu += e.Cpus
 		totalMemory += e.Memory
+		totalDisk += e.Disk
 	}
 
 	return &citadel.ClusterInfo{
 		Cpus:           totalCpu,
 		Memory:         totalMemory,
+		Disk:           totalDisk,
 		ContainerCount: containerCount,
 		ImageCount:     imageCount,
 		EngineCount:    engineCount,
 		ReservedCpus:   reservedCpus,
 		ReservedMemory: reservedMemory,
+		ReservedDisk:   reservedDisk,
 	}, nil
 }
 
@@ -0,0 +1,310 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/citadel/citadel"
+	"github.com/samalba/dockerclient"
+)
+
+// recorder is an event handler that keeps the events it receives
+type recorder struct {
+	mux    sync.Mutex
+	events []*citadel.Event
+}
+
+func (r *recorder) Handle(e *citadel.Event) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.events = append(r.events, e)
+
+	return nil
+}
+
+func (r *recorder) received() []*citadel.Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return append([]*citadel.Event{}, r.events...)
+}
+
+// testEngine is an engine whose docker API is served by a test server with no
+// containers.  The server's /_ping fails while healthy is false, /volumes lists
+// volumes or fails when volumesFail is set and every pull is sent on pulled after
+// onPull, if set, returns.
+type testEngine struct {
+	*citadel.Engine
+
+	server      *httptest.Server
+	mux         sync.Mutex
+	healthy     bool
+	volumes     []string
+	volumesFail bool
+	onPull      func()
+	pulled      chan string
+}
+
+func newTestEngine(t *testing.T, id string) *testEngine {
+	te := &testEngine{healthy: true, pulled: make(chan string, 100)}
+
+	m := http.NewServeMux()
+
+	m.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		te.mux.Lock()
+		defer te.mux.Unlock()
+
+		if !te.healthy {
+			http.Error(w, "engine is down", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprint(w, "OK")
+	})
+
+	m.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "[]")
+	})
+
+	m.HandleFunc("/volumes", func(w http.ResponseWriter, r *http.Request) {
+		te.mux.Lock()
+		defer te.mux.Unlock()
+
+		if te.volumesFail {
+			http.Error(w, "unable to list volumes", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprint(w, `{"Volumes":[`)
+		for i, v := range te.volumes {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"Name":%q}`, v)
+		}
+		fmt.Fprint(w, "]}")
+	})
+
+	m.HandleFunc("/images/create", func(w http.ResponseWriter, r *http.Request) {
+		te.mux.Lock()
+		onPull := te.onPull
+		te.mux.Unlock()
+
+		if onPull != nil {
+			onPull()
+		}
+
+		te.pulled <- r.URL.Query().Get("fromImage")
+
+		fmt.Fprint(w, `{"status":"Downloaded newer image"}`)
+	})
+
+	m.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+	})
+
+	te.server = httptest.NewServer(m)
+
+	u, err := url.Parse(te.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	te.Engine = &citadel.Engine{ID: id, Addr: te.server.URL, Cpus: 4, Memory: 4096}
+	te.SetClient(&dockerclient.DockerClient{URL: u, HTTPClient: http.DefaultClient})
+
+	return te
+}
+
+func (te *testEngine) setHealthy(healthy bool) {
+	te.mux.Lock()
+	defer te.mux.Unlock()
+
+	te.healthy = healthy
+}
+
+type acceptAll struct{}
+
+func (acceptAll) Schedule(*citadel.Image, *citadel.Engine) (bool, error) {
+	return true, nil
+}
+
+// noPlacement records the engines it is offered and never places the container
+type noPlacement struct {
+	offered []string
+}
+
+func (m *noPlacement) PlaceContainer(c *citadel.Container, engines []*citadel.EngineSnapshot) (*citadel.EngineSnapshot, error) {
+	for _, e := range engines {
+		m.offered = append(m.offered, e.ID)
+	}
+
+	return nil, fmt.Errorf("no placement in tests")
+}
+
+func TestRescheduleExcludesSourceEngine(t *testing.T) {
+	var (
+		source  = newTestEngine(t, "source")
+		other   = newTestEngine(t, "other")
+		manager = &noPlacement{}
+		r       = &recorder{}
+	)
+	defer source.server.Close()
+	defer other.server.Close()
+
+	c, err := New(manager, source.Engine, other.Engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.RegisterScheduler("service", acceptAll{})
+	c.eventHandlers = append(c.eventHandlers, r)
+
+	container := &citadel.Container{
+		ID:     "1",
+		Engine: source.Engine,
+		Image:  &citadel.Image{Name: "crosbymichael/redis", Type: "service"},
+	}
+
+	if _, err := c.Reschedule(container, "test", false); err == nil {
+		t.Fatal("expected the reschedule to fail without a placement")
+	}
+
+	if len(manager.offered) != 1 || manager.offered[0] != "other" {
+		t.Fatalf("expected only the other engine to be offered received %v", manager.offered)
+	}
+
+	events := r.received()
+	if len(events) != 1 || events[0].Type != citadel.EventPlacementFailed {
+		t.Fatalf("expected a placement-failed event received %d events", len(events))
+	}
+
+	if _, rejected := events[0].Schedule.Rejected["source"]; !rejected {
+		t.Fatalf("expected the source engine to be rejected received %v", events[0].Schedule.Rejected)
+	}
+}
+
+func TestAddEngineAttachesEventHandlers(t *testing.T) {
+	var (
+		e = newTestEngine(t, "local")
+		r = &recorder{}
+	)
+	defer e.server.Close()
+
+	c, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Events(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.AddEngine(e.Engine); err != nil {
+		t.Fatal(err)
+	}
+
+	// adding the handler again fails when the cluster attached it to the engine
+	if err := e.Events(r); err == nil {
+		t.Fatal("expected the cluster's handler to be attached to the engine")
+	}
+
+	if err := c.RemoveEngine(e.Engine); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.RemoveEventHandler(r); err == nil {
+		t.Fatal("expected the cluster's handler to be detached from the removed engine")
+	}
+
+	events := r.received()
+	if len(events) != 2 || events[0].Type != citadel.EventEngineAdded || events[1].Type != citadel.EventEngineRemoved {
+		t.Fatalf("expected engine-added and engine-removed events received %d events", len(events))
+	}
+}
+
+func TestEventsRollsBackOnFailure(t *testing.T) {
+	var (
+		first  = newTestEngine(t, "first")
+		second = newTestEngine(t, "second")
+		r      = &recorder{}
+	)
+	defer first.server.Close()
+	defer second.server.Close()
+
+	c, err := New(nil, first.Engine, second.Engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the handler is already attached to the second engine so the cluster cannot add it
+	if err := second.Events(r); err != nil {
+		t.Fatal(err)
+	}
+	defer second.StopEvents()
+
+	if err := c.Events(r); err == nil {
+		t.Fatal("expected adding the handler to fail")
+	}
+
+	if err := first.RemoveEventHandler(r); err == nil {
+		t.Fatal("expected the handler to be removed from the first engine")
+	}
+
+	if err := second.RemoveEventHandler(r); err != nil {
+		t.Fatalf("expected the handler attached before to be kept received %s", err)
+	}
+
+	if len(c.eventHandlers) != 0 {
+		t.Fatal("expected the handler not to be added to the cluster")
+	}
+}
+
+func TestVolumeEnginesSkipsUnhealthyAndFailingEngines(t *testing.T) {
+	var (
+		holding   = newTestEngine(t, "holding")
+		failing   = newTestEngine(t, "failing")
+		unhealthy = newTestEngine(t, "unhealthy")
+		schedule  = &citadel.Schedule{Rejected: make(map[string]string)}
+		image     = &citadel.Image{
+			Name:    "crosbymichael/redis",
+			Volumes: []*citadel.Volume{{Name: "data"}},
+		}
+	)
+	defer holding.server.Close()
+	defer failing.server.Close()
+	defer unhealthy.server.Close()
+
+	holding.volumes = []string{"data"}
+	failing.volumesFail = true
+	unhealthy.volumes = []string{"data"}
+
+	c, err := New(&noPlacement{}, holding.Engine, failing.Engine, unhealthy.Engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.unhealthy["unhealthy"] = "engine is down"
+
+	engines, err := c.volumeEngines(image, schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(engines) != 1 || engines[0].ID != "holding" {
+		t.Fatalf("expected only the holding engine received %d engines", len(engines))
+	}
+
+	for _, id := range []string{"failing", "unhealthy"} {
+		if _, rejected := schedule.Rejected[id]; !rejected {
+			t.Fatalf("expected %s to be rejected received %v", id, schedule.Rejected)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"log"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+// publish sends an event of the cluster to each of the cluster's event handlers.  It must
+// not be called while holding the cluster's lock so that handlers can use the cluster.
+func (c *Cluster) publish(event *citadel.Event) {
+	c.mux.Lock()
+	handlers := append([]citadel.EventHandler{}, c.eventHandlers...)
+	c.mux.Unlock()
+
+	for _, h := range handlers {
+		if err := h.Handle(event); err != nil {
+			log.Printf("cluster: unable to handle %s event: %s\n", event.Type, err)
+		}
+	}
+}
+
+// publishSchedule publishes the scheduling decision made for the image, a scheduled event
+// when an engine was chosen and a placement-failed event otherwise
+func (c *Cluster) publishSchedule(image *citadel.Image, container *citadel.Container, schedule *citadel.Schedule, err error) {
+	event := &citadel.Event{
+		Type:     citadel.EventScheduled,
+		Image:    image,
+		Schedule: schedule,
+		Time:     time.Now(),
+	}
+
+	if schedule == nil || schedule.Engine == "" {
+		event.Type = citadel.EventPlacementFailed
+	}
+
+	if container != nil {
+		event.Container = container
+		event.Engine = container.Engine
+	}
+
+	if err != nil {
+		event.Reason = err.Error()
+	}
+
+	c.publish(event)
+}
@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+// CheckHealth pings every engine in the cluster and publishes an event for each engine
+// whose health changed since the last check.  Unhealthy engines are not scheduled on.
+func (c *Cluster) CheckHealth() {
+	events := []*citadel.Event{}
+
+	for _, e := range c.Engines() {
+		err := e.Ping()
+
+		c.mux.Lock()
+		reason, unhealthy := c.unhealthy[e.ID]
+
+		switch {
+		case err != nil && !unhealthy:
+			c.unhealthy[e.ID] = err.Error()
+
+			events = append(events, &citadel.Event{
+				Type:   citadel.EventEngineUnhealthy,
+				Engine: e,
+				Reason: err.Error(),
+				Time:   time.Now(),
+			})
+		case err == nil && unhealthy:
+			delete(c.unhealthy, e.ID)
+
+			events = append(events, &citadel.Event{
+				Type:   citadel.EventEngineHealthy,
+				Engine: e,
+				Reason: reason,
+				Time:   time.Now(),
+			})
+		}
+		c.mux.Unlock()
+	}
+
+	for _, event := range events {
+		c.publish(event)
+	}
+}
+
+// MonitorHealth checks the health of the engines at the interval until stop is closed
+func (c *Cluster) MonitorHealth(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.CheckHealth()
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Healthy returns false if the engine failed its last health check
+func (c *Cluster) Healthy(e *citadel.Engine) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	_, unhealthy := c.unhealthy[e.ID]
+
+	return !unhealthy
+}
@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/citadel/citadel"
+)
+
+func TestCheckHealthPublishesTransitions(t *testing.T) {
+	var (
+		e = newTestEngine(t, "local")
+		r = &recorder{}
+	)
+	defer e.server.Close()
+
+	c, err := New(nil, e.Engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.eventHandlers = append(c.eventHandlers, r)
+
+	c.CheckHealth()
+
+	if len(r.received()) != 0 || !c.Healthy(e.Engine) {
+		t.Fatal("expected a healthy engine not to publish events")
+	}
+
+	e.setHealthy(false)
+
+	// a failing engine only publishes when its health changes
+	c.CheckHealth()
+	c.CheckHealth()
+
+	if events := r.received(); len(events) != 1 || events[0].Type != citadel.EventEngineUnhealthy {
+		t.Fatalf("expected an engine-unhealthy event received %d events", len(events))
+	}
+
+	if c.Healthy(e.Engine) {
+		t.Fatal("expected the engine to be unhealthy")
+	}
+
+	e.setHealthy(true)
+	c.CheckHealth()
+
+	events := r.received()
+	if len(events) != 2 || events[1].Type != citadel.EventEngineHealthy || events[1].Engine.ID != "local" {
+		t.Fatalf("expected an engine-healthy event received %d events", len(events))
+	}
+
+	if !c.Healthy(e.Engine) {
+		t.Fatal("expected the engine to be healthy again")
+	}
+}
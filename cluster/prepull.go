@@ -0,0 +1,210 @@
+package cluster
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/citadel/citadel"
+)
+
+// PullResult is the outcome of pulling an image on an engine
+type PullResult struct {
+	Engine *citadel.Engine `json:"engine,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// EnginePullProgressFunc is called with every progress message of a pull on an engine
+type EnginePullProgressFunc func(*citadel.Engine, *citadel.PullProgress)
+
+type prePullPolicy struct {
+	image    *citadel.Image
+	selector citadel.Scheduler
+}
+
+// SetPullConcurrency sets the number of engines that pull an image at the same time,
+// zero means that all engines pull at once
+func (c *Cluster) SetPullConcurrency(n int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.pullConcurrency = n
+}
+
+// PrePull pulls the image in parallel on the engines accepted by the selector so that
+// later placements do not wait on cold pulls.  A nil selector selects every engine.
+// Unhealthy engines and engines that the selector fails on are skipped and reported in
+// the results.
+func (c *Cluster) PrePull(image *citadel.Image, selector citadel.Scheduler, progress EnginePullProgressFunc) ([]*PullResult, error) {
+	c.mux.Lock()
+	var (
+		engines     = []*citadel.Engine{}
+		unhealthy   = make(map[string]string)
+		concurrency = c.pullConcurrency
+	)
+
+	for _, e := range c.engines {
+		engines = append(engines, e)
+	}
+
+	for id, reason := range c.unhealthy {
+		unhealthy[id] = reason
+	}
+	c.mux.Unlock()
+
+	var (
+		selected = []*citadel.Engine{}
+		skipped  = []*PullResult{}
+	)
+
+	for _, e := range engines {
+		if reason, exists := unhealthy[e.ID]; exists {
+			skipped = append(skipped, &PullResult{Engine: e, Error: fmt.Sprintf("engine is unhealthy: %s", reason)})
+			continue
+		}
+
+		canrun, err := selects(selector, image, e)
+		if err != nil {
+			skipped = append(skipped, &PullResult{Engine: e, Error: err.Error()})
+			continue
+		}
+
+		if canrun {
+			selected = append(selected, e)
+		}
+	}
+
+	return append(c.pull(image, selected, concurrency, progress), skipped...), nil
+}
+
+// selects returns true if the selector accepts the engine for the image, a nil selector
+// accepts every engine
+func selects(selector citadel.Scheduler, image *citadel.Image, e *citadel.Engine) (bool, error) {
+	if selector == nil {
+		return true, nil
+	}
+
+	return selector.Schedule(image, e)
+}
+
+func (c *Cluster) pull(image *citadel.Image, engines []*citadel.Engine, concurrency int, progress EnginePullProgressFunc) []*PullResult {
+	if concurrency <= 0 {
+		concurrency = len(engines)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		limit   = make(chan struct{}, concurrency)
+		results = make([]*PullResult, len(engines))
+	)
+
+	for i, e := range engines {
+		wg.Add(1)
+
+		go func(i int, e *citadel.Engine) {
+			defer wg.Done()
+
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			var f citadel.PullProgressFunc
+			if progress != nil {
+				f = func(p *citadel.PullProgress) {
+					progress(e, p)
+				}
+			}
+
+			c.mux.Lock()
+			auth := c.registryAuthFor(e, image.Name)
+			c.mux.Unlock()
+
+			results[i] = &PullResult{Engine: e}
+
+			if err := e.Pull(image.Name, auth, f); err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, e)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// AddPrePullPolicy keeps the image pulled on every engine accepted by the selector,
+// including engines that are added to the cluster later.  A nil selector selects every
+// engine.
+func (c *Cluster) AddPrePullPolicy(image *citadel.Image, selector citadel.Scheduler) {
+	c.mux.Lock()
+	c.prePullPolicies = append(c.prePullPolicies, &prePullPolicy{
+		image:    image,
+		selector: selector,
+	})
+	c.mux.Unlock()
+
+	go func() {
+		results, err := c.PrePull(image, selector, nil)
+		logPullResults(image, results, err)
+	}()
+}
+
+// PrePullImages returns the images that the pre-pull policies keep on the engine.  An
+// image is returned when its selector fails so that it is not removed by mistake.
+func (c *Cluster) PrePullImages(e *citadel.Engine) []*citadel.Image {
+	c.mux.Lock()
+	policies := c.prePullPolicies
+	c.mux.Unlock()
+
+	out := []*citadel.Image{}
+
+	for _, p := range policies {
+		if canrun, err := selects(p.selector, p.image, e); canrun || err != nil {
+			out = append(out, p.image)
+		}
+	}
+
+	return out
+}
+
+// ApplyPrePullPolicies pulls the images of the pre-pull policies that select the engine
+// again, for example after images were removed from it
+func (c *Cluster) ApplyPrePullPolicies(e *citadel.Engine) {
+	c.mux.Lock()
+	policies := c.prePullPolicies
+	c.mux.Unlock()
+
+	c.applyPrePullPolicies(e, policies)
+}
+
+// applyPrePullPolicies pulls the images of the pre-pull policies that select the engine
+// unless the engine is unhealthy
+func (c *Cluster) applyPrePullPolicies(e *citadel.Engine, policies []*prePullPolicy) {
+	if !c.Healthy(e) {
+		return
+	}
+
+	for _, p := range policies {
+		canrun, err := selects(p.selector, p.image, e)
+		if err != nil {
+			logPullResults(p.image, []*PullResult{{Engine: e, Error: err.Error()}}, nil)
+			continue
+		}
+
+		if canrun {
+			logPullResults(p.image, c.pull(p.image, []*citadel.Engine{e}, 1, nil), nil)
+		}
+	}
+}
+
+func logPullResults(image *citadel.Image, results []*PullResult, err error) {
+	if err != nil {
+		log.Printf("cluster: unable to pre-pull %s: %s\n", image.Name, err)
+		return
+	}
+
+	for _, r := range results {
+		if r.Error != "" {
+			log.Printf("cluster: unable to pre-pull %s on %s: %s\n", image.Name, r.Engine, r.Error)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package cluster
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+// failOn is a selector that fails on one engine and accepts the others
+type failOn string
+
+func (f failOn) Schedule(i *citadel.Image, e *citadel.Engine) (bool, error) {
+	if e.ID == string(f) {
+		return false, fmt.Errorf("unable to select %s", e.ID)
+	}
+
+	return true, nil
+}
+
+func pullCount(te *testEngine) int {
+	return len(te.pulled)
+}
+
+func waitForPull(t *testing.T, te *testEngine) {
+	select {
+	case <-te.pulled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the image to be pulled on %s", te.ID)
+	}
+}
+
+func TestPrePullSkipsFailingAndUnhealthyEngines(t *testing.T) {
+	var (
+		healthy   = newTestEngine(t, "healthy")
+		failing   = newTestEngine(t, "failing")
+		unhealthy = newTestEngine(t, "unhealthy")
+	)
+	defer healthy.server.Close()
+	defer failing.server.Close()
+	defer unhealthy.server.Close()
+
+	c, err := New(&noPlacement{}, healthy.Engine, failing.Engine, unhealthy.Engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.unhealthy["unhealthy"] = "engine is down"
+
+	results, err := c.PrePull(&citadel.Image{Name: "crosbymichael/redis"}, failOn("failing"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results received %d", len(results))
+	}
+
+	for _, r := range results {
+		failed := r.Error != ""
+
+		if expected := r.Engine.ID != "healthy"; failed != expected {
+			t.Fatalf("expected the result for %s to have an error %v received %q", r.Engine.ID, expected, r.Error)
+		}
+	}
+
+	if n := pullCount(healthy); n != 1 {
+		t.Fatalf("expected 1 pull on the healthy engine received %d", n)
+	}
+
+	if n := pullCount(failing) + pullCount(unhealthy); n != 0 {
+		t.Fatalf("expected no pulls on the skipped engines received %d", n)
+	}
+}
+
+func TestPrePullConcurrency(t *testing.T) {
+	var (
+		mux     sync.Mutex
+		active  int
+		max     int
+		engines = []*citadel.Engine{}
+	)
+
+	onPull := func() {
+		mux.Lock()
+		active++
+		if active > max {
+			max = active
+		}
+		mux.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mux.Lock()
+		active--
+		mux.Unlock()
+	}
+
+	for i := 0; i < 4; i++ {
+		te := newTestEngine(t, fmt.Sprintf("engine-%d", i))
+		defer te.server.Close()
+
+		te.onPull = onPull
+		engines = append(engines, te.Engine)
+	}
+
+	c, err := New(&noPlacement{}, engines...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetPullConcurrency(2)
+
+	results, err := c.PrePull(&citadel.Image{Name: "crosbymichael/redis"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range results {
+		if r.Error != "" {
+			t.Fatalf("expected no pull errors received %s", r.Error)
+		}
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	if len(results) != 4 || max != 2 {
+		t.Fatalf("expected 4 pulls with at most 2 at once received %d pulls and %d at once", len(results), max)
+	}
+}
+
+func TestAddPrePullPolicyPullsOnNewEngines(t *testing.T) {
+	var (
+		first  = newTestEngine(t, "first")
+		second = newTestEngine(t, "second")
+	)
+	defer first.server.Close()
+	defer second.server.Close()
+
+	c, err := New(&noPlacement{}, first.Engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.AddPrePullPolicy(&citadel.Image{Name: "crosbymichael/redis"}, nil)
+	waitForPull(t, first)
+
+	if err := c.AddEngine(second.Engine); err != nil {
+		t.Fatal(err)
+	}
+	waitForPull(t, second)
+}
+
+func TestApplyPrePullPolicies(t *testing.T) {
+	var (
+		selected = newTestEngine(t, "selected")
+		failing  = newTestEngine(t, "failing")
+	)
+	defer selected.server.Close()
+	defer failing.server.Close()
+
+	c, err := New(&noPlacement{}, selected.Engine, failing.Engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.prePullPolicies = []*prePullPolicy{
+		{image: &citadel.Image{Name: "crosbymichael/redis"}, selector: failOn("failing")},
+		{image: &citadel.Image{Name: "busybox"}, selector: nil},
+	}
+
+	c.ApplyPrePullPolicies(selected.Engine)
+	c.ApplyPrePullPolicies(failing.Engine)
+
+	if n := pullCount(selected); n != 2 {
+		t.Fatalf("expected both images to be pulled on the selected engine received %d pulls", n)
+	}
+
+	if n := pullCount(failing); n != 1 {
+		t.Fatalf("expected only the policy without a selector to pull on the failing engine received %d pulls", n)
+	}
+
+	c.unhealthy["selected"] = "engine is down"
+	c.ApplyPrePullPolicies(selected.Engine)
+
+	if n := pullCount(selected); n != 2 {
+		t.Fatalf("expected no pulls on the unhealthy engine received %d pulls", n-2)
+	}
+}
@@ -1,6 +1,9 @@
 package citadel
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Container is a running instance
 type Container struct {
@@ -13,6 +16,9 @@ type Container struct {
 	// Image is the configuration from which the container was created
 	Image *Image `json:"image,omitempty"`
 
+	// ImageID is the id of the image on the engine that the container was created from
+	ImageID string `json:"image_id,omitempty"`
+
 	// Engine is the engine that is runnnig the container
 	Engine *Engine `json:"engine,omitempty"`
 
@@ -21,6 +27,46 @@ type Container struct {
 
 	// Ports are the public port mappings for the container
 	Ports []*Port `json:"ports,omitempty"`
+
+	// ExitCode is the exit code of the container's last run
+	ExitCode int `json:"exit_code,omitempty"`
+
+	// StartedAt is when the container was last started
+	StartedAt time.Time `json:"started_at,omitempty"`
+
+	// FinishedAt is when the container last exited
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+
+	// OOMKilled is true if the container's last run was killed for running out of memory
+	OOMKilled bool `json:"oom_killed,omitempty"`
+
+	// Crash is the restart history of the container tracked by a crash loop detector
+	Crash *CrashState `json:"crash,omitempty"`
+}
+
+// CrashState is the restart history of a container over a detector's window
+type CrashState struct {
+	// Restarts is the number of times the container exited in the window
+	Restarts int `json:"restarts"`
+
+	// OOMKills is the number of times the container was killed for running out of memory in the window
+	OOMKills int `json:"oom_kills"`
+
+	// CrashLoop is true when the container restarted too often in the window
+	CrashLoop bool `json:"crash_loop"`
+
+	// ServiceCrashLoop is true when the containers of the container's service restarted too
+	// often in the window
+	ServiceCrashLoop bool `json:"service_crash_loop"`
+
+	// Backoff is how long automatic restarts and reschedules of the container are held back
+	Backoff time.Duration `json:"backoff,omitempty"`
+}
+
+// RestartBackoff decides how long to wait before a container is automatically restarted
+// or rescheduled
+type RestartBackoff interface {
+	Backoff(*Container) time.Duration
 }
 
 func (c *Container) String() string {
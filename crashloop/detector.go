@@ -0,0 +1,358 @@
+package crashloop
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/citadel/citadel"
+	"github.com/citadel/citadel/cluster"
+)
+
+// Policy controls when containers and services are flagged as crash looping and how their
+// automatic restarts are backed off
+type Policy struct {
+	// Window is how far back restarts are counted
+	Window time.Duration `json:"window,omitempty"`
+
+	// MaxRestarts is the number of restarts of a container in the window that is a crash loop
+	MaxRestarts int `json:"max_restarts,omitempty"`
+
+	// MaxServiceRestarts is the number of restarts of a service's containers in the window
+	// that is a crash loop
+	MaxServiceRestarts int `json:"max_service_restarts,omitempty"`
+
+	// Backoff is the wait before restarting a crash looping container, it doubles for every
+	// restart over MaxRestarts up to MaxBackoff
+	Backoff    time.Duration `json:"backoff,omitempty"`
+	MaxBackoff time.Duration `json:"max_backoff,omitempty"`
+}
+
+// DefaultPolicy flags containers restarting 5 times in 5 minutes
+var DefaultPolicy = Policy{
+	Window:             5 * time.Minute,
+	MaxRestarts:        5,
+	MaxServiceRestarts: 10,
+	Backoff:            10 * time.Second,
+	MaxBackoff:         5 * time.Minute,
+}
+
+// Detector is an EventHandler that tracks the restarts of containers and services from
+// their die and oom events to find crash loops and oom kills
+type Detector struct {
+	mux sync.Mutex
+
+	policy  Policy
+	handler citadel.EventHandler
+	cluster *cluster.Cluster
+
+	containers map[string]*tracker
+	services   map[string]*tracker
+}
+
+// New returns a detector publishing its alerts to the handler, the handler can be nil.
+// Fields of the policy that are not set use the DefaultPolicy.
+func New(policy Policy, handler citadel.EventHandler) *Detector {
+	if policy.Window <= 0 {
+		policy.Window = DefaultPolicy.Window
+	}
+
+	if policy.MaxRestarts <= 0 {
+		policy.MaxRestarts = DefaultPolicy.MaxRestarts
+	}
+
+	if policy.MaxServiceRestarts <= 0 {
+		policy.MaxServiceRestarts = DefaultPolicy.MaxServiceRestarts
+	}
+
+	if policy.Backoff <= 0 {
+		policy.Backoff = DefaultPolicy.Backoff
+	}
+
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = DefaultPolicy.MaxBackoff
+	}
+
+	return &Detector{
+		policy:     policy,
+		handler:    handler,
+		containers: make(map[string]*tracker),
+		services:   make(map[string]*tracker),
+	}
+}
+
+// Throttle stops crash looping containers that are restarted automatically while they are
+// backed off and restarts them on the cluster once their backoff has passed
+func (d *Detector) Throttle(c *cluster.Cluster) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.cluster = c
+}
+
+// Handle tracks the restarts of the event's container
+func (d *Detector) Handle(e *citadel.Event) error {
+	if e.Container == nil || e.Container.Image == nil {
+		return nil
+	}
+
+	var (
+		c      = e.Container
+		alerts = []*citadel.Event{}
+		now    = e.Time
+	)
+
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	d.mux.Lock()
+
+	t := d.containers[c.ID]
+	if t == nil {
+		t = &tracker{}
+		d.containers[c.ID] = t
+	}
+
+	service := d.services[serviceKey(c.Image)]
+	if service == nil {
+		service = &tracker{}
+		d.services[serviceKey(c.Image)] = service
+	}
+
+	switch e.Type {
+	case citadel.EventOOM:
+		t.oomKills = append(t.oomKills, now)
+
+		alerts = append(alerts, d.alert(citadel.EventOOMKilled, c, "container ran out of memory"))
+	case citadel.EventDie:
+		if t.throttled {
+			// the container was stopped by the detector and not by a crash
+			break
+		}
+
+		t.restarts = append(t.restarts, now)
+		service.restarts = append(service.restarts, now)
+		t.lastExit, service.lastExit = now, now
+
+		if c.OOMKilled && !t.sawOOM(now) {
+			t.oomKills = append(t.oomKills, now)
+
+			alerts = append(alerts, d.alert(citadel.EventOOMKilled, c, "container ran out of memory"))
+		}
+	case citadel.EventStart:
+		if t.throttled {
+			t.throttled = false
+		} else if backoff := d.backoff(t, now); t.looping && backoff > 0 && d.cluster != nil {
+			t.throttled = true
+
+			go d.throttle(d.cluster, c, backoff)
+		}
+	case citadel.EventDestroy:
+		delete(d.containers, c.ID)
+
+		// a destroyed container has no state to update
+		t = &tracker{}
+	}
+
+	window := now.Add(-d.policy.Window)
+	t.prune(window)
+	service.prune(window)
+
+	if len(service.restarts) == 0 && !service.looping {
+		delete(d.services, serviceKey(c.Image))
+	}
+
+	switch t.update(d.policy.MaxRestarts) {
+	case started:
+		alerts = append(alerts, d.alert(citadel.EventCrashLoop, c,
+			fmt.Sprintf("container restarted %d times in %s", len(t.restarts), d.policy.Window)))
+	case stopped:
+		alerts = append(alerts, d.alert(citadel.EventCrashLoopRecovered, c,
+			fmt.Sprintf("container restarted %d times in %s", len(t.restarts), d.policy.Window)))
+	}
+
+	if service.update(d.policy.MaxServiceRestarts) == started {
+		alerts = append(alerts, &citadel.Event{
+			Type:   citadel.EventServiceCrashLoop,
+			Image:  c.Image,
+			Reason: fmt.Sprintf("%s restarted %d times in %s", serviceKey(c.Image), len(service.restarts), d.policy.Window),
+			Time:   time.Now(),
+		})
+	}
+
+	d.mux.Unlock()
+
+	d.publish(alerts)
+
+	return nil
+}
+
+// State returns the crash state of the container, nil if it has not exited
+func (d *Detector) State(c *citadel.Container) *citadel.CrashState {
+	d.mux.Lock()
+
+	var (
+		now     = time.Now()
+		window  = now.Add(-d.policy.Window)
+		t       = d.containers[c.ID]
+		service *tracker
+		alerts  []*citadel.Event
+	)
+
+	if c.Image != nil {
+		service = d.services[serviceKey(c.Image)]
+	}
+
+	if t == nil && service == nil {
+		d.mux.Unlock()
+		return nil
+	}
+
+	state := &citadel.CrashState{}
+
+	if t != nil {
+		alerts = d.expire(t, c, now)
+
+		state.Restarts = len(t.restarts)
+		state.OOMKills = len(t.oomKills)
+		state.CrashLoop = t.looping
+		state.Backoff = d.backoff(t, now)
+	}
+
+	if service != nil {
+		service.prune(window)
+		service.update(d.policy.MaxServiceRestarts)
+
+		state.ServiceCrashLoop = service.looping
+	}
+
+	d.mux.Unlock()
+
+	d.publish(alerts)
+
+	return state
+}
+
+// Annotate sets the crash state of the containers
+func (d *Detector) Annotate(containers ...*citadel.Container) {
+	for _, c := range containers {
+		c.Crash = d.State(c)
+	}
+}
+
+// Backoff returns how long automatic restarts and reschedules of the container are held
+// back because it is crash looping
+func (d *Detector) Backoff(c *citadel.Container) time.Duration {
+	d.mux.Lock()
+
+	t := d.containers[c.ID]
+	if t == nil {
+		d.mux.Unlock()
+		return 0
+	}
+
+	var (
+		now     = time.Now()
+		alerts  = d.expire(t, c, now)
+		backoff = d.backoff(t, now)
+	)
+
+	d.mux.Unlock()
+
+	d.publish(alerts)
+
+	return backoff
+}
+
+// expire drops the container's restarts that are out of the window and returns the
+// crash-loop-recovered alert if it is no longer crash looping, the lock must be held
+func (d *Detector) expire(t *tracker, c *citadel.Container, now time.Time) []*citadel.Event {
+	t.prune(now.Add(-d.policy.Window))
+
+	if t.update(d.policy.MaxRestarts) != stopped {
+		return nil
+	}
+
+	return []*citadel.Event{d.alert(citadel.EventCrashLoopRecovered, c,
+		fmt.Sprintf("container restarted %d times in %s", len(t.restarts), d.policy.Window))}
+}
+
+// backoff returns the wait left before the tracked container can be restarted
+func (d *Detector) backoff(t *tracker, now time.Time) time.Duration {
+	if !t.looping {
+		return 0
+	}
+
+	wait := d.policy.Backoff
+	for i := d.policy.MaxRestarts; i < len(t.restarts) && wait < d.policy.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > d.policy.MaxBackoff {
+		wait = d.policy.MaxBackoff
+	}
+
+	if left := t.lastExit.Add(wait).Sub(now); left > 0 {
+		return left
+	}
+
+	return 0
+}
+
+// throttle stops the container that was restarted during its backoff and starts it again
+// once the backoff has passed
+func (d *Detector) throttle(c *cluster.Cluster, container *citadel.Container, backoff time.Duration) {
+	log.Printf("crashloop: holding back %s for %s\n", container.ID, backoff)
+
+	if _, err := c.Stop(container); err != nil {
+		log.Printf("crashloop: unable to stop %s: %s\n", container.ID, err)
+
+		d.mux.Lock()
+		if t := d.containers[container.ID]; t != nil {
+			t.throttled = false
+		}
+		d.mux.Unlock()
+
+		return
+	}
+
+	time.AfterFunc(backoff, func() {
+		if err := c.Restart(container, 0); err != nil {
+			log.Printf("crashloop: unable to restart %s: %s\n", container.ID, err)
+		}
+	})
+}
+
+func (d *Detector) alert(tpe citadel.EventType, c *citadel.Container, reason string) *citadel.Event {
+	return &citadel.Event{
+		Type:      tpe,
+		Container: c,
+		Engine:    c.Engine,
+		Reason:    reason,
+		Time:      time.Now(),
+	}
+}
+
+func (d *Detector) publish(alerts []*citadel.Event) {
+	if d.handler == nil {
+		return
+	}
+
+	for _, a := range alerts {
+		if err := d.handler.Handle(a); err != nil {
+			log.Printf("crashloop: unable to publish %s event: %s\n", a.Type, err)
+		}
+	}
+}
+
+// serviceKey returns the key that the restarts of the image's containers are tracked by,
+// its service or its image reference
+func serviceKey(i *citadel.Image) string {
+	if i.Service != "" {
+		return i.Service
+	}
+
+	return citadel.ParseReference(i.Name).String()
+}
@@ -0,0 +1,110 @@
+package crashloop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+type recorder struct {
+	events []*citadel.Event
+}
+
+func (r *recorder) Handle(e *citadel.Event) error {
+	r.events = append(r.events, e)
+
+	return nil
+}
+
+func (r *recorder) types() []citadel.EventType {
+	out := []citadel.EventType{}
+	for _, e := range r.events {
+		out = append(out, e.Type)
+	}
+
+	return out
+}
+
+func TestDetectorFlagsCrashLoops(t *testing.T) {
+	var (
+		r = &recorder{}
+		d = New(Policy{MaxRestarts: 3, Backoff: time.Minute}, r)
+		c = &citadel.Container{
+			ID:    "1",
+			Image: &citadel.Image{Name: "crosbymichael/redis", Service: "redis"},
+		}
+	)
+
+	for i := 0; i < 3; i++ {
+		d.Handle(&citadel.Event{Type: citadel.EventDie, Container: c, Time: time.Now()})
+	}
+
+	if len(r.events) != 1 || r.events[0].Type != citadel.EventCrashLoop {
+		t.Fatalf("expected a crash loop alert received %v", r.types())
+	}
+
+	state := d.State(c)
+	if state == nil || !state.CrashLoop || state.Restarts != 3 {
+		t.Fatalf("expected the container to be crash looping with 3 restarts received %+v", state)
+	}
+
+	if state.ServiceCrashLoop {
+		t.Fatal("expected the service not to be crash looping")
+	}
+
+	if b := d.Backoff(c); b <= 0 || b > time.Minute {
+		t.Fatalf("expected a backoff of up to a minute received %s", b)
+	}
+}
+
+func TestDetectorFlagsOOMKills(t *testing.T) {
+	var (
+		r = &recorder{}
+		d = New(Policy{}, r)
+		c = &citadel.Container{
+			ID:    "1",
+			Image: &citadel.Image{Name: "crosbymichael/redis"},
+		}
+	)
+
+	d.Handle(&citadel.Event{Type: citadel.EventOOM, Container: c, Time: time.Now()})
+
+	c.OOMKilled = true
+	d.Handle(&citadel.Event{Type: citadel.EventDie, Container: c, Time: time.Now()})
+
+	// the die event of an oom killed container does not raise a second alert
+	if len(r.events) != 1 || r.events[0].Type != citadel.EventOOMKilled {
+		t.Fatalf("expected one oom alert received %v", r.types())
+	}
+
+	if state := d.State(c); state.OOMKills != 1 || state.CrashLoop {
+		t.Fatalf("expected one oom kill without a crash loop received %+v", state)
+	}
+}
+
+func TestDetectorPublishesRecovery(t *testing.T) {
+	var (
+		r = &recorder{}
+		d = New(Policy{Window: time.Minute, MaxRestarts: 2}, r)
+		c = &citadel.Container{
+			ID:    "1",
+			Image: &citadel.Image{Name: "crosbymichael/redis"},
+		}
+		crashed = time.Now().Add(-2 * time.Minute)
+	)
+
+	for i := 0; i < 2; i++ {
+		d.Handle(&citadel.Event{Type: citadel.EventDie, Container: c, Time: crashed})
+	}
+
+	// the restarts are now out of the window so reading the state ends the crash loop
+	if state := d.State(c); state.CrashLoop || state.Restarts != 0 {
+		t.Fatalf("expected the container to have recovered received %+v", state)
+	}
+
+	types := r.types()
+	if len(types) != 2 || types[0] != citadel.EventCrashLoop || types[1] != citadel.EventCrashLoopRecovered {
+		t.Fatalf("expected a crash loop and a recovered alert received %v", types)
+	}
+}
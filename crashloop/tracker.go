@@ -0,0 +1,63 @@
+package crashloop
+
+import "time"
+
+type change int
+
+const (
+	unchanged change = iota
+	started
+	stopped
+)
+
+// tracker is the restart history of a container or service over the detector's window
+type tracker struct {
+	restarts []time.Time
+	oomKills []time.Time
+	lastExit time.Time
+	looping  bool
+
+	// throttled is true while the detector holds back the container's restart
+	throttled bool
+}
+
+// prune drops the restarts and oom kills that happened before the start of the window
+func (t *tracker) prune(start time.Time) {
+	t.restarts = after(t.restarts, start)
+	t.oomKills = after(t.oomKills, start)
+}
+
+// update sets whether the tracker is crash looping with max restarts in the window
+func (t *tracker) update(max int) change {
+	looping := max > 0 && len(t.restarts) >= max
+
+	switch {
+	case looping && !t.looping:
+		t.looping = true
+		return started
+	case !looping && t.looping:
+		t.looping = false
+		return stopped
+	}
+
+	return unchanged
+}
+
+// sawOOM returns true if an oom kill was recorded for the container's exit at the time
+func (t *tracker) sawOOM(exit time.Time) bool {
+	if len(t.oomKills) == 0 {
+		return false
+	}
+
+	return exit.Sub(t.oomKills[len(t.oomKills)-1]) < time.Minute
+}
+
+func after(times []time.Time, start time.Time) []time.Time {
+	for i, t := range times {
+		if !t.Before(start) {
+			return times[i:]
+		}
+	}
+
+	return nil
+}
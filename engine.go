@@ -2,8 +2,10 @@ package citadel
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/samalba/dockerclient"
@@ -14,10 +16,45 @@ type Engine struct {
 	Addr   string   `json:"addr,omitempty"`
 	Cpus   float64  `json:"cpus,omitempty"`
 	Memory float64  `json:"memory,omitempty"`
+	Disk   float64  `json:"disk,omitempty"`
 	Labels []string `json:"labels,omitempty"`
 
-	client       *dockerclient.DockerClient
-	eventHandler EventHandler
+	// RegistryAuth are the credentials used by the engine to pull images, they are read
+	// from json but never written
+	RegistryAuth []*RegistryAuth `json:"registry_auth,omitempty"`
+
+	client      *dockerclient.DockerClient
+	tlsConfig   *tls.Config
+	eventMux    sync.Mutex
+	eventQueues []*eventQueue
+	eventStop   chan struct{}
+
+	eventErrorHandler EventErrorHandler
+	eventRetry        *EventRetryPolicy
+	containers        map[string]*knownContainer
+}
+
+// engineJSON is the encoded form of an engine, registry credentials are never encoded so
+// that they do not leak through the apis and events that include engines
+type engineJSON struct {
+	ID     string   `json:"id,omitempty"`
+	Addr   string   `json:"addr,omitempty"`
+	Cpus   float64  `json:"cpus,omitempty"`
+	Memory float64  `json:"memory,omitempty"`
+	Disk   float64  `json:"disk,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+// MarshalJSON encodes the engine without its registry credentials
+func (e *Engine) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&engineJSON{
+		ID:     e.ID,
+		Addr:   e.Addr,
+		Cpus:   e.Cpus,
+		Memory: e.Memory,
+		Disk:   e.Disk,
+		Labels: e.Labels,
+	})
 }
 
 func (e *Engine) Connect(config *tls.Config) error {
@@ -27,6 +64,7 @@ func (e *Engine) Connect(config *tls.Config) error {
 	}
 
 	e.client = c
+	e.tlsConfig = config
 
 	return nil
 }
@@ -40,6 +78,18 @@ func (e *Engine) IsConnected() bool {
 	return e.client != nil
 }
 
+// Ping checks that the engine's docker remote API is responding
+func (e *Engine) Ping() error {
+	resp, err := e.request("GET", "/_ping", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// Start creates and starts the container from its image.  Ports set on the container before
+// it is started are used as the host ports of the image's bind ports that do not have one.
 func (e *Engine) Start(c *Container, pullImage bool) error {
 	var (
 		err    error
@@ -53,20 +103,36 @@ func (e *Engine) Start(c *Container, pullImage bool) error {
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
-	env = append(env,
-		fmt.Sprintf("_citadel_type=%s", i.Type),
-		fmt.Sprintf("_citadel_labels=%s", strings.Join(i.Labels, ",")),
-	)
+	labels, err := containerLabels(i)
+	if err != nil {
+		return err
+	}
 
 	config := &dockerclient.ContainerConfig{
 		Hostname:     i.Hostname,
 		Domainname:   i.Domainname,
 		Image:        i.Name,
 		Cmd:          i.Args,
+		Entrypoint:   i.Entrypoint,
+		WorkingDir:   i.WorkingDir,
+		User:         i.User,
 		Memory:       int(i.Memory) * 1024 * 1024,
+		MemorySwap:   int(i.MemorySwap) * 1024 * 1024,
 		Env:          env,
+		Labels:       labels,
 		CpuShares:    int(i.Cpus * 100.0 / e.Cpus),
+		Cpuset:       i.Cpuset,
 		ExposedPorts: make(map[string]struct{}),
+		Volumes:      make(map[string]struct{}),
+	}
+
+	// docker uses -1 for unlimited swap
+	if i.MemorySwap < 0 {
+		config.MemorySwap = -1
+	}
+
+	if err := applyUserData(i, config); err != nil {
+		return err
 	}
 
 	links := []string{}
@@ -74,7 +140,19 @@ func (e *Engine) Start(c *Container, pullImage bool) error {
 		links = append(links, fmt.Sprintf("%s:%s", k, v))
 	}
 
+	binds := []string{}
+	for _, v := range i.Volumes {
+		b := v.bind()
+		if b == "" {
+			config.Volumes[v.ContainerPath] = struct{}{}
+			continue
+		}
+
+		binds = append(binds, b)
+	}
+
 	hostConfig := &dockerclient.HostConfig{
+		Binds:           binds,
 		PublishAllPorts: i.Publish,
 		PortBindings:    make(map[string][]dockerclient.PortBinding),
 		Links:           links,
@@ -83,22 +161,55 @@ func (e *Engine) Start(c *Container, pullImage bool) error {
 			MaximumRetryCount: i.RestartPolicy.MaximumRetryCount,
 		},
 		NetworkMode: i.NetworkMode,
+		Privileged:  i.Privileged,
+		CapAdd:      i.CapAdd,
+		CapDrop:     i.CapDrop,
+		Dns:         i.Dns,
+		ExtraHosts:  i.ExtraHosts,
+	}
+
+	for _, u := range i.Ulimits {
+		hostConfig.Ulimits = append(hostConfig.Ulimits, dockerclient.Ulimit{
+			Name: u.Name,
+			Soft: u.Soft,
+			Hard: u.Hard,
+		})
+	}
+
+	for _, d := range i.Devices {
+		hostConfig.Devices = append(hostConfig.Devices, dockerclient.DeviceMapping{
+			PathOnHost:        d.PathOnHost,
+			PathInContainer:   d.PathInContainer,
+			CgroupPermissions: d.CgroupPermissions,
+		})
+	}
+
+	if i.LogConfig != nil {
+		hostConfig.LogConfig = dockerclient.LogConfig{
+			Type:   i.LogConfig.Type,
+			Config: i.LogConfig.Config,
+		}
 	}
 
 	for _, b := range i.BindPorts {
 		key := fmt.Sprintf("%d/%s", b.ContainerPort, b.Proto)
 		config.ExposedPorts[key] = struct{}{}
 
-		hostConfig.PortBindings[key] = []dockerclient.PortBinding{
-			{
-				HostPort: fmt.Sprint(b.Port),
-			},
+		port := b.Port
+		if port == 0 {
+			port = allocatedPort(c.Ports, b)
 		}
+
+		binding := dockerclient.PortBinding{}
+		if port != 0 {
+			binding.HostPort = fmt.Sprint(port)
+		}
+
+		hostConfig.PortBindings[key] = []dockerclient.PortBinding{binding}
 	}
 
-	imageInfo := parseImageName(i.Name)
 	if pullImage {
-		if err := client.PullImage(imageInfo.Name, imageInfo.Tag); err != nil {
+		if err := e.Pull(i.Name, e.RegistryAuthFor(i.Name), nil); err != nil {
 			return err
 		}
 	}
@@ -107,13 +218,39 @@ func (e *Engine) Start(c *Container, pullImage bool) error {
 		return err
 	}
 
+	if err := e.copyUserData(c); err != nil {
+		return err
+	}
+
 	if err := client.StartContainer(c.ID, hostConfig); err != nil {
 		return err
 	}
 
+	// the allocated ports are replaced by the mappings reported by docker
+	c.Ports = nil
+
 	return e.updatePortInformation(c)
 }
 
+// allocatedPort returns the host port allocated for the bind port, zero if there is none
+func allocatedPort(allocated []*Port, b *Port) int {
+	for _, p := range allocated {
+		if p.ContainerPort == b.ContainerPort && portProto(p.Proto) == portProto(b.Proto) {
+			return p.Port
+		}
+	}
+
+	return 0
+}
+
+func portProto(proto string) string {
+	if proto == "" {
+		return "tcp"
+	}
+
+	return strings.ToLower(proto)
+}
+
 func (e *Engine) ListImages() ([]string, error) {
 	images, err := e.client.ListImages()
 	if err != nil {
@@ -131,6 +268,51 @@ func (e *Engine) ListImages() ([]string, error) {
 	return out, nil
 }
 
+// EngineImages returns the images stored on the engine with their tags and digests
+func (e *Engine) EngineImages() ([]*EngineImage, error) {
+	resp, err := e.request("GET", "/images/json", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var images []struct {
+		Id          string
+		RepoTags    []string
+		RepoDigests []string
+		Created     int64
+		VirtualSize int64
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&images); err != nil {
+		return nil, err
+	}
+
+	out := []*EngineImage{}
+
+	for _, i := range images {
+		out = append(out, &EngineImage{
+			ID:      i.Id,
+			Tags:    i.RepoTags,
+			Digests: i.RepoDigests,
+			Created: time.Unix(i.Created, 0),
+			Size:    i.VirtualSize,
+		})
+	}
+
+	return out, nil
+}
+
+// RemoveImage removes the image from the engine
+func (e *Engine) RemoveImage(name string) error {
+	resp, err := e.request("DELETE", fmt.Sprintf("/images/%s", name), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
 func (e *Engine) updatePortInformation(c *Container) error {
 	info, err := e.client.InspectContainer(c.ID)
 	if err != nil {
@@ -154,18 +336,22 @@ func (e *Engine) ListContainers(all bool) ([]*Container, error) {
 			return nil, err
 		}
 
+		e.rememberContainer(cc)
+
 		out = append(out, cc)
 	}
 
 	return out, nil
 }
 
+// Kill sends the signal to the container, docker sends SIGKILL when sig is zero
 func (e *Engine) Kill(container *Container, sig int) error {
-	return e.client.KillContainer(container.ID)
+	return e.Signal(container, signalName(sig))
 }
 
+// Stop stops the container with docker giving it the image's stop timeout to exit
 func (e *Engine) Stop(container *Container) error {
-	return e.client.StopContainer(container.ID, 8)
+	return e.client.StopContainer(container.ID, int(container.Image.stopTimeout()/time.Second))
 }
 
 func (e *Engine) Restart(container *Container, timeout int) error {
@@ -176,17 +362,84 @@ func (e *Engine) Remove(container *Container) error {
 	return e.client.RemoveContainer(container.ID)
 }
 
+// Events adds the handler to the engine's event handlers and starts monitoring the
+// engine's docker events if it is not already.  The event stream is reconnected with a
+// backoff when it drops and resumes where it left off.
 func (e *Engine) Events(h EventHandler) error {
-	if e.eventHandler != nil {
-		return fmt.Errorf("event handler already set")
+	// a handler that cannot be compared could never be removed
+	if !SameHandler(h, h) {
+		return fmt.Errorf("event handler %T cannot be compared, use a pointer", h)
+	}
+
+	e.eventMux.Lock()
+	defer e.eventMux.Unlock()
+
+	for _, q := range e.eventQueues {
+		if SameHandler(q.handler, h) {
+			return fmt.Errorf("event handler already added")
+		}
 	}
-	e.eventHandler = h
+	e.eventQueues = append(e.eventQueues, e.newEventQueue(h))
 
-	e.client.StartMonitorEvents(e.handler)
+	if e.eventStop == nil {
+		e.eventStop = make(chan struct{})
+
+		go e.monitorEvents(e.eventStop, func(ev *dockerclient.Event) {
+			e.handler(ev)
+		})
+	}
+
+	return nil
+}
+
+// RemoveEventHandler removes the handler from the engine's event handlers and stops
+// monitoring the engine's events when it was the last one
+func (e *Engine) RemoveEventHandler(h EventHandler) error {
+	e.eventMux.Lock()
+	defer e.eventMux.Unlock()
+
+	for i, q := range e.eventQueues {
+		if SameHandler(q.handler, h) {
+			close(q.done)
+			e.eventQueues = append(e.eventQueues[:i], e.eventQueues[i+1:]...)
+
+			if len(e.eventQueues) == 0 {
+				e.stopEvents()
+			}
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("event handler is not added to the engine")
+}
+
+// StopEvents stops monitoring the engine's events and removes all the event handlers
+func (e *Engine) StopEvents() error {
+	e.eventMux.Lock()
+	defer e.eventMux.Unlock()
+
+	if e.eventStop == nil {
+		return fmt.Errorf("engine events are not being monitored")
+	}
+
+	for _, q := range e.eventQueues {
+		close(q.done)
+	}
+
+	e.eventQueues = nil
+	e.stopEvents()
 
 	return nil
 }
 
+func (e *Engine) stopEvents() {
+	close(e.eventStop)
+
+	e.eventStop = nil
+	e.containers = nil
+}
+
 func (e *Engine) String() string {
 	return fmt.Sprintf("engine %s addr %s", e.ID, e.Addr)
 }
@@ -194,17 +447,32 @@ func (e *Engine) String() string {
 func (e *Engine) handler(ev *dockerclient.Event, args ...interface{}) {
 	event := &Event{
 		Engine: e,
-		Type:   ev.Status,
+		Type:   EventType(ev.Status),
 		Time:   time.Unix(int64(ev.Time), 0),
 	}
 
 	container, err := FromDockerContainer(ev.Id, ev.From, e)
-	if err != nil {
-		// TODO: un fuck this shit, fuckin handler
+	switch {
+	case err == nil:
+		e.rememberContainer(container)
+	case e.lastKnownContainer(ev.Id) != nil:
+		// the container no longer exists in docker, destroy events for example
+		container = e.lastKnownContainer(ev.Id)
+	default:
+		e.eventError(&EventError{
+			Event: event,
+			Error: err.Error(),
+			Time:  time.Now(),
+		})
+
 		return
 	}
 
 	event.Container = container
 
-	e.eventHandler.Handle(event)
+	e.deliver(event)
+
+	if event.Type == EventDestroy {
+		e.forgetContainer(ev.Id)
+	}
 }
@@ -0,0 +1,163 @@
+package citadel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/samalba/dockerclient"
+)
+
+const (
+	minEventBackoff = 1 * time.Second
+	maxEventBackoff = 1 * time.Minute
+
+	// maxSeenEvents bounds the events remembered at the time of the last event
+	maxSeenEvents = 1024
+)
+
+// eventCursor is the position in the engine's event stream that the stream is resumed
+// from when it drops
+type eventCursor struct {
+	since int64
+
+	// seen are the events at since, used to drop the duplicates that docker sends again
+	// when the stream is resumed from that time
+	seen map[string]bool
+}
+
+// next moves the cursor to the event and returns false if the event was already received
+func (c *eventCursor) next(ev *dockerclient.Event) bool {
+	key := fmt.Sprintf("%s %s %d", ev.Id, ev.Status, ev.Time)
+
+	if int64(ev.Time) < c.since || c.seen[key] {
+		return false
+	}
+
+	// a burst of events within the same second only risks a few duplicates on resume
+	// once it is over the bound
+	if int64(ev.Time) > c.since || len(c.seen) >= maxSeenEvents {
+		c.since = int64(ev.Time)
+		c.seen = make(map[string]bool)
+	}
+
+	c.seen[key] = true
+
+	return true
+}
+
+// monitorEvents streams the engine's events to handle until stop is closed.  When the
+// stream drops it is reconnected with an exponential backoff and resumed from the time
+// of the last event received so that no events are lost.
+func (e *Engine) monitorEvents(stop chan struct{}, handle func(*dockerclient.Event)) {
+	var (
+		cursor  = &eventCursor{}
+		lost    bool
+		backoff = minEventBackoff
+	)
+
+	connected := func() {
+		// resume from the first connection if the stream drops before any events
+		if cursor.since == 0 {
+			cursor.since = time.Now().Unix()
+		}
+
+		if lost {
+			e.deliver(&Event{
+				Type:   EventsResumed,
+				Engine: e,
+				Time:   time.Now(),
+				Reason: fmt.Sprintf("resumed from %s", time.Unix(cursor.since, 0)),
+			})
+		}
+
+		lost = false
+		backoff = minEventBackoff
+	}
+
+	received := func(ev *dockerclient.Event) {
+		if cursor.next(ev) {
+			handle(ev)
+		}
+	}
+
+	for {
+		err := e.streamEvents(cursor.since, stop, connected, received)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if err == nil {
+			err = io.EOF
+		}
+
+		if !lost {
+			log.Printf("engine %s: event stream lost: %s\n", e.ID, err)
+
+			e.deliver(&Event{
+				Type:   EventsLost,
+				Engine: e,
+				Time:   time.Now(),
+				Reason: err.Error(),
+			})
+
+			lost = true
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > maxEventBackoff {
+			backoff = maxEventBackoff
+		}
+	}
+}
+
+// streamEvents reads the engine's event stream starting at since, or now when since is
+// zero, until the stream ends or stop is closed
+func (e *Engine) streamEvents(since int64, stop chan struct{}, connected func(), received func(*dockerclient.Event)) error {
+	query := url.Values{}
+	if since > 0 {
+		query.Set("since", strconv.FormatInt(since, 10))
+	}
+
+	resp, err := e.request("GET", "/events", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-stop:
+			resp.Body.Close()
+		case <-done:
+		}
+	}()
+
+	connected()
+
+	dec := json.NewDecoder(resp.Body)
+
+	for {
+		var ev *dockerclient.Event
+		if err := dec.Decode(&ev); err != nil {
+			return err
+		}
+
+		received(ev)
+	}
+}
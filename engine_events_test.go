@@ -0,0 +1,154 @@
+package citadel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samalba/dockerclient"
+)
+
+func TestEventCursorDropsDuplicates(t *testing.T) {
+	c := &eventCursor{}
+
+	for _, ev := range []*dockerclient.Event{
+		{Id: "1", Status: "start", Time: 100},
+		{Id: "2", Status: "start", Time: 100},
+	} {
+		if !c.next(ev) {
+			t.Fatalf("expected %s %s to be new", ev.Id, ev.Status)
+		}
+	}
+
+	// docker sends the events at the resumed time again
+	if c.next(&dockerclient.Event{Id: "1", Status: "start", Time: 100}) {
+		t.Fatal("expected the duplicate event to be dropped")
+	}
+
+	if c.next(&dockerclient.Event{Id: "3", Status: "start", Time: 99}) {
+		t.Fatal("expected an event before the cursor to be dropped")
+	}
+
+	if !c.next(&dockerclient.Event{Id: "1", Status: "die", Time: 101}) || c.since != 101 || len(c.seen) != 1 {
+		t.Fatalf("expected the cursor to move to 101 received %d with %d seen", c.since, len(c.seen))
+	}
+}
+
+func TestEventCursorBoundsSeen(t *testing.T) {
+	c := &eventCursor{}
+
+	for i := 0; i < maxSeenEvents*2; i++ {
+		c.next(&dockerclient.Event{Id: fmt.Sprint(i), Status: "start", Time: 100})
+	}
+
+	if len(c.seen) > maxSeenEvents {
+		t.Fatalf("expected at most %d seen events received %d", maxSeenEvents, len(c.seen))
+	}
+}
+
+type eventRecorder struct {
+	mux    sync.Mutex
+	events []string
+}
+
+func (r *eventRecorder) Handle(e *Event) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.events = append(r.events, string(e.Type))
+
+	return nil
+}
+
+func (r *eventRecorder) received() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return append([]string{}, r.events...)
+}
+
+func TestMonitorEventsResumes(t *testing.T) {
+	var (
+		mux         sync.Mutex
+		connections int
+		resumed     string
+
+		// the events are after the time the stream is first connected
+		ts = time.Now().Unix() + 10
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		connections++
+		n := connections
+		mux.Unlock()
+
+		if n == 1 {
+			// the stream drops after two events
+			fmt.Fprintf(w, `{"id": "1", "status": "start", "time": %d}`, ts)
+			fmt.Fprintf(w, `{"id": "2", "status": "start", "time": %d}`, ts)
+			return
+		}
+
+		mux.Lock()
+		resumed = r.URL.Query().Get("since")
+		mux.Unlock()
+
+		fmt.Fprintf(w, `{"id": "2", "status": "start", "time": %d}`, ts)
+		fmt.Fprintf(w, `{"id": "1", "status": "die", "time": %d}`, ts+1)
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		r    = &eventRecorder{}
+		stop = make(chan struct{})
+		done = make(chan struct{})
+		e    = &Engine{ID: "test", Addr: server.URL}
+	)
+
+	e.SetClient(&dockerclient.DockerClient{URL: u, HTTPClient: http.DefaultClient})
+	e.eventQueues = []*eventQueue{e.newEventQueue(r)}
+	defer closeQueues(e)
+
+	go func() {
+		defer close(done)
+
+		e.monitorEvents(stop, func(ev *dockerclient.Event) {
+			e.deliver(&Event{Type: EventType(fmt.Sprintf("%s %s", ev.Status, ev.Id))})
+		})
+	}()
+
+	expected := []string{"start 1", "start 2", string(EventsLost), string(EventsResumed), "die 1"}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.received()) < len(expected) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(stop)
+	<-done
+
+	received := r.received()
+	if fmt.Sprint(received) != fmt.Sprint(expected) {
+		t.Fatalf("expected events %v received %v", expected, received)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	if resumed != fmt.Sprint(ts) {
+		t.Fatalf("expected the stream to resume from %d received %s", ts, resumed)
+	}
+}
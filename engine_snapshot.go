@@ -8,15 +8,21 @@ type EngineSnapshot struct {
 
 	Memory float64 `json:"memory,omitempty"`
 
+	// Disk is the amount of disk in MB that the engine advertises, zero if unknown
+	Disk float64 `json:"disk,omitempty"`
+
 	// ReservedCpus is the total amount of cpus that is reserved
 	ReservedCpus float64 `json:"reserved_cpus,omitempty"`
 
 	// ReservedMemory is the total amount of memory that is reserved
 	ReservedMemory float64 `json:"reserved_memory,omitempty"`
 
-	// CurrentMemory is the current system's used memory at the time of the snapshot
+	// ReservedDisk is the total amount of disk that is reserved
+	ReservedDisk float64 `json:"reserved_disk,omitempty"`
+
+	// CurrentMemory is the current system's used memory in MB at the time of the snapshot
 	CurrentMemory float64 `json:"current_memory,omitempty"`
 
-	// CurrentCpu is the current system's cpu usage at the time of the snapshot
+	// CurrentCpu is the current system's cpu usage, in cpus, at the time of the snapshot
 	CurrentCpu float64 `json:"current_cpu,omitempty"`
 }
@@ -0,0 +1,34 @@
+package citadel
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEngineJSONHidesRegistryAuth(t *testing.T) {
+	var e *Engine
+
+	config := `{"id": "local", "addr": "tcp://127.0.0.1:2375", "registry_auth": [{"username": "ops", "password": "s3cr3t"}]}`
+
+	if err := json.Unmarshal([]byte(config), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.RegistryAuth) != 1 || e.RegistryAuth[0].Password != "s3cr3t" {
+		t.Fatal("expected registry credentials to be loaded from json")
+	}
+
+	data, err := json.Marshal(&Container{ID: "1", Engine: e})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "s3cr3t") || strings.Contains(string(data), "registry_auth") {
+		t.Fatalf("expected registry credentials not to be encoded received %s", data)
+	}
+
+	if !strings.Contains(string(data), `"id":"local"`) {
+		t.Fatalf("expected the engine to be encoded received %s", data)
+	}
+}
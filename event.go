@@ -1,14 +1,180 @@
 package citadel
 
-import "time"
+import (
+	"reflect"
+	"time"
+)
+
+// EventType is the type of an event, the docker status of container events
+type EventType string
+
+// container event types sent by docker
+const (
+	EventCreate  EventType = "create"
+	EventStart   EventType = "start"
+	EventRestart EventType = "restart"
+	EventDie     EventType = "die"
+	EventKill    EventType = "kill"
+	EventStop    EventType = "stop"
+	EventOOM     EventType = "oom"
+	EventPause   EventType = "pause"
+	EventUnpause EventType = "unpause"
+	EventDestroy EventType = "destroy"
+)
+
+// event types published by citadel
+const (
+	// EventPull is published for the progress messages of an image pull
+	EventPull EventType = "pull"
+
+	// EventGCContainer is published when the garbage collector removes a container
+	EventGCContainer EventType = "gc-container"
+
+	// EventGCImage is published when the garbage collector removes an image
+	EventGCImage EventType = "gc-image"
+
+	// EventsLost is published when the event stream of an engine drops
+	EventsLost EventType = "engine-events-lost"
+
+	// EventsResumed is published when the event stream of an engine is reconnected
+	EventsResumed EventType = "engine-events-resumed"
+)
+
+// event types published by the cluster
+const (
+	// EventEngineAdded is published when an engine is added to the cluster
+	EventEngineAdded EventType = "engine-added"
+
+	// EventEngineRemoved is published when an engine is removed from the cluster
+	EventEngineRemoved EventType = "engine-removed"
+
+	// EventEngineHealthy is published when an unhealthy engine responds again
+	EventEngineHealthy EventType = "engine-healthy"
+
+	// EventEngineUnhealthy is published when an engine stops responding
+	EventEngineUnhealthy EventType = "engine-unhealthy"
+
+	// EventScheduled is published when an engine is chosen to run an image
+	EventScheduled EventType = "scheduled"
+
+	// EventPlacementFailed is published when no engine can run an image
+	EventPlacementFailed EventType = "placement-failed"
+
+	// EventPreempted is published when a container is removed to make room for others
+	EventPreempted EventType = "preempted"
+
+	// EventRescheduled is published when a container is replaced by one on another engine
+	EventRescheduled EventType = "rescheduled"
+)
+
+// event types published by crash loop detectors
+const (
+	// EventCrashLoop is published when a container restarts too often
+	EventCrashLoop EventType = "crash-loop"
+
+	// EventCrashLoopRecovered is published when a crash looping container stops restarting
+	EventCrashLoopRecovered EventType = "crash-loop-recovered"
+
+	// EventServiceCrashLoop is published when the containers of a service restart too often
+	EventServiceCrashLoop EventType = "service-crash-loop"
+
+	// EventOOMKilled is published when a container is killed for running out of memory
+	EventOOMKilled EventType = "oom-killed"
+)
+
+// Schedule is the decision made by the cluster when placing an image
+type Schedule struct {
+	// Accepted are the ids of the engines accepted by the image's scheduler
+	Accepted []string `json:"accepted,omitempty"`
+
+	// Rejected are the ids of the engines rejected for the image with the reason
+	Rejected map[string]string `json:"rejected,omitempty"`
+
+	// Engine is the id of the engine chosen by the resource manager
+	Engine string `json:"engine,omitempty"`
+}
 
 type Event struct {
-	Type      string     `json:"type,omitempty"`
+	Type      EventType  `json:"type,omitempty"`
 	Container *Container `json:"container,omitempty"`
 	Engine    *Engine    `json:"engine,omitempty"`
 	Time      time.Time  `json:"time,omitempty"`
+
+	// Image is the image that the event is about when there is no container
+	Image *Image `json:"image,omitempty"`
+
+	// Reason is a description of why citadel published the event
+	Reason string `json:"reason,omitempty"`
+
+	// Pull is the progress of an image pull for pull events
+	Pull *PullProgress `json:"pull,omitempty"`
+
+	// Schedule is the scheduling decision for scheduled and placement-failed events
+	Schedule *Schedule `json:"schedule,omitempty"`
+
+	// Previous is the container that was replaced for rescheduled events
+	Previous *Container `json:"previous,omitempty"`
+
+	// Sequence is the position of the event in an event log, zero if it was not logged
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+// Record returns a copy of the event to be stored or sent outside of citadel.  The engines
+// of the copy only have their description without credentials.
+func (e *Event) Record() *Event {
+	r := *e
+
+	r.Engine = e.Engine.description()
+	r.Container = e.Container.record()
+	r.Previous = e.Previous.record()
+
+	return &r
 }
 
+func (c *Container) record() *Container {
+	if c == nil {
+		return nil
+	}
+
+	r := *c
+	r.Engine = c.Engine.description()
+
+	return &r
+}
+
+func (e *Engine) description() *Engine {
+	if e == nil {
+		return nil
+	}
+
+	return &Engine{
+		ID:     e.ID,
+		Addr:   e.Addr,
+		Cpus:   e.Cpus,
+		Memory: e.Memory,
+		Disk:   e.Disk,
+		Labels: e.Labels,
+	}
+}
+
+// EventHandler receives events.  Handlers are found by comparing them with == when they
+// are removed so they must be of a comparable type, a pointer for example.
 type EventHandler interface {
 	Handle(*Event) error
 }
+
+// SameHandler returns true if a and b are the same handler.  A handler whose type cannot
+// be compared, a func or a struct holding a map for example, is not the same as any
+// handler, itself included.
+func SameHandler(a, b EventHandler) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+
+	return a == b
+}
@@ -0,0 +1,295 @@
+package citadel
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// EventError is an event that could not be built or delivered to an event handler
+type EventError struct {
+	// Event is the event that failed, its container is nil if it could not be built
+	Event *Event `json:"event,omitempty"`
+
+	// Error is the last error returned while building or delivering the event
+	Error string `json:"error,omitempty"`
+
+	// Attempts is the number of times delivery was attempted
+	Attempts int `json:"attempts,omitempty"`
+
+	Time time.Time `json:"time,omitempty"`
+}
+
+// EventErrorHandler receives the events that an engine could not deliver
+type EventErrorHandler interface {
+	HandleEventError(*EventError)
+}
+
+// EventRetryPolicy controls how delivery of an event to a handler is retried
+type EventRetryPolicy struct {
+	// Attempts is the total number of deliveries attempted for an event
+	Attempts int `json:"attempts,omitempty"`
+
+	// Backoff is the wait before the first retry, doubled after each retry
+	Backoff time.Duration `json:"backoff,omitempty"`
+}
+
+const (
+	// eventQueueSize is the number of events queued for each of an engine's handlers
+	eventQueueSize = 1024
+
+	// maxKnownContainers is the number of containers an engine remembers for their events
+	maxKnownContainers = 4096
+
+	// DefaultDeadLetterQueueSize is the number of failed events kept by a dead letter queue
+	DefaultDeadLetterQueueSize = 100
+)
+
+var defaultEventRetryPolicy = EventRetryPolicy{
+	Attempts: 3,
+	Backoff:  100 * time.Millisecond,
+}
+
+// DeadLetterQueue is an EventErrorHandler that keeps the last events that could not be
+// delivered so that they can be inspected or handled again
+type DeadLetterQueue struct {
+	mux sync.Mutex
+
+	size   int
+	errors []*EventError
+}
+
+// NewDeadLetterQueue returns a queue that keeps the last size failed events, sizes below
+// one keep DefaultDeadLetterQueueSize events
+func NewDeadLetterQueue(size int) *DeadLetterQueue {
+	if size < 1 {
+		size = DefaultDeadLetterQueueSize
+	}
+
+	return &DeadLetterQueue{
+		size: size,
+	}
+}
+
+func (q *DeadLetterQueue) HandleEventError(err *EventError) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.errors = append(q.errors, err)
+
+	if len(q.errors) > q.size {
+		q.errors = q.errors[len(q.errors)-q.size:]
+	}
+}
+
+// Errors returns the failed events in the queue
+func (q *DeadLetterQueue) Errors() []*EventError {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	out := make([]*EventError, len(q.errors))
+	copy(out, q.errors)
+
+	return out
+}
+
+// Drain removes and returns the failed events in the queue
+func (q *DeadLetterQueue) Drain() []*EventError {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	out := q.errors
+	q.errors = nil
+
+	return out
+}
+
+// SetEventErrorHandler sets where the engine sends events that it could not build or
+// deliver.  Errors are logged when no handler is set.
+func (e *Engine) SetEventErrorHandler(h EventErrorHandler) {
+	e.eventMux.Lock()
+	defer e.eventMux.Unlock()
+
+	e.eventErrorHandler = h
+}
+
+// SetEventRetryPolicy sets how delivery of an event to the engine's handlers is retried.
+// Events are always delivered at least once even if Attempts is not set.
+func (e *Engine) SetEventRetryPolicy(p EventRetryPolicy) {
+	if p.Attempts < 1 {
+		p.Attempts = 1
+	}
+
+	e.eventMux.Lock()
+	defer e.eventMux.Unlock()
+
+	e.eventRetry = &p
+}
+
+// eventQueue delivers the engine's events to a handler on its own goroutine so
+// that a handler being retried does not hold back the engine's other handlers
+type eventQueue struct {
+	handler EventHandler
+	events  chan *Event
+	done    chan struct{}
+}
+
+// newEventQueue starts delivering events to the handler until the queue's done is closed
+func (e *Engine) newEventQueue(h EventHandler) *eventQueue {
+	q := &eventQueue{
+		handler: h,
+		events:  make(chan *Event, eventQueueSize),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			select {
+			case event := <-q.events:
+				e.deliverTo(q, event, e.retryPolicy())
+			case <-q.done:
+				return
+			}
+		}
+	}()
+
+	return q
+}
+
+// deliver queues the event for each of the engine's handlers.  Events for a handler whose
+// queue is full are sent to the engine's event error handler.
+func (e *Engine) deliver(event *Event) {
+	e.eventMux.Lock()
+	queues := append([]*eventQueue{}, e.eventQueues...)
+	e.eventMux.Unlock()
+
+	for _, q := range queues {
+		select {
+		case q.events <- event:
+		case <-q.done:
+		default:
+			e.eventError(&EventError{
+				Event: event,
+				Error: fmt.Sprintf("event queue of %T is full", q.handler),
+				Time:  time.Now(),
+			})
+		}
+	}
+}
+
+func (e *Engine) retryPolicy() EventRetryPolicy {
+	e.eventMux.Lock()
+	defer e.eventMux.Unlock()
+
+	if e.eventRetry != nil {
+		return *e.eventRetry
+	}
+
+	return defaultEventRetryPolicy
+}
+
+// deliverTo sends the event to the queue's handler retrying with the policy
+func (e *Engine) deliverTo(q *eventQueue, event *Event, policy EventRetryPolicy) {
+	var (
+		err      error
+		backoff  = policy.Backoff
+		attempts = policy.Attempts
+	)
+
+	// the event is always delivered once so that a failure has an error to report
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = q.handler.Handle(event); err == nil {
+			return
+		}
+
+		if attempt < attempts {
+			select {
+			case <-time.After(backoff):
+			case <-q.done:
+				return
+			}
+
+			backoff *= 2
+		}
+	}
+
+	e.eventError(&EventError{
+		Event:    event,
+		Error:    err.Error(),
+		Attempts: attempts,
+		Time:     time.Now(),
+	})
+}
+
+func (e *Engine) eventError(err *EventError) {
+	e.eventMux.Lock()
+	h := e.eventErrorHandler
+	e.eventMux.Unlock()
+
+	if h == nil {
+		log.Printf("engine %s: unable to deliver %s event: %s\n", e.ID, err.Event.Type, err.Error)
+		return
+	}
+
+	h.HandleEventError(err)
+}
+
+// knownContainer is the last known state of a container and when it was last seen
+type knownContainer struct {
+	container *Container
+	seen      time.Time
+}
+
+// rememberContainer keeps the last known state of the container so that events can be
+// delivered for it after it is removed from docker.  Containers are only remembered while
+// the engine's events are monitored and the container seen least recently is forgotten
+// once maxKnownContainers are remembered.
+func (e *Engine) rememberContainer(c *Container) {
+	e.eventMux.Lock()
+	defer e.eventMux.Unlock()
+
+	if e.eventStop == nil {
+		return
+	}
+
+	if e.containers == nil {
+		e.containers = make(map[string]*knownContainer)
+	}
+
+	if _, exists := e.containers[c.ID]; !exists && len(e.containers) >= maxKnownContainers {
+		var oldest *knownContainer
+
+		for _, k := range e.containers {
+			if oldest == nil || k.seen.Before(oldest.seen) {
+				oldest = k
+			}
+		}
+
+		delete(e.containers, oldest.container.ID)
+	}
+
+	e.containers[c.ID] = &knownContainer{container: c, seen: time.Now()}
+}
+
+func (e *Engine) lastKnownContainer(id string) *Container {
+	e.eventMux.Lock()
+	defer e.eventMux.Unlock()
+
+	if k := e.containers[id]; k != nil {
+		return k.container
+	}
+
+	return nil
+}
+
+func (e *Engine) forgetContainer(id string) {
+	e.eventMux.Lock()
+	defer e.eventMux.Unlock()
+
+	delete(e.containers, id)
+}
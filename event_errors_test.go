@@ -0,0 +1,165 @@
+package citadel
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyHandler fails the first failures deliveries and blocks while block is open
+type flakyHandler struct {
+	mux      sync.Mutex
+	failures int
+	attempts int
+	handled  int
+	block    chan struct{}
+}
+
+func (h *flakyHandler) Handle(e *Event) error {
+	if h.block != nil {
+		<-h.block
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.attempts++
+	if h.attempts <= h.failures {
+		return fmt.Errorf("attempt %d failed", h.attempts)
+	}
+
+	h.handled++
+
+	return nil
+}
+
+func (h *flakyHandler) counts() (int, int) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	return h.attempts, h.handled
+}
+
+func waitUntil(t *testing.T, f func() bool) {
+	deadline := time.Now().Add(time.Second)
+
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the events to be delivered")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func closeQueues(e *Engine) {
+	for _, q := range e.eventQueues {
+		close(q.done)
+	}
+}
+
+func TestEventRetryPolicy(t *testing.T) {
+	var (
+		e       = &Engine{ID: "test"}
+		dlq     = NewDeadLetterQueue(10)
+		flaky   = &flakyHandler{failures: 2}
+		failing = &flakyHandler{failures: 100}
+	)
+
+	e.SetEventRetryPolicy(EventRetryPolicy{Attempts: 3, Backoff: time.Millisecond})
+	e.SetEventErrorHandler(dlq)
+
+	e.eventQueues = []*eventQueue{e.newEventQueue(flaky), e.newEventQueue(failing)}
+	defer closeQueues(e)
+
+	e.deliver(&Event{Type: EventStart})
+
+	waitUntil(t, func() bool {
+		attempts, _ := failing.counts()
+		return attempts == 3 && len(dlq.Errors()) == 1
+	})
+
+	if attempts, handled := flaky.counts(); attempts != 3 || handled != 1 {
+		t.Fatalf("expected the event to be handled on the third attempt received %d attempts and %d handled", attempts, handled)
+	}
+
+	errors := dlq.Drain()
+	if len(errors) != 1 || errors[0].Attempts != 3 || errors[0].Event.Type != EventStart {
+		t.Fatalf("expected the failed event in the dead letter queue received %+v", errors)
+	}
+}
+
+func TestSlowHandlerDoesNotBlockDelivery(t *testing.T) {
+	var (
+		e    = &Engine{ID: "test"}
+		slow = &flakyHandler{block: make(chan struct{})}
+		fast = &flakyHandler{}
+	)
+
+	e.eventQueues = []*eventQueue{e.newEventQueue(slow), e.newEventQueue(fast)}
+	defer closeQueues(e)
+
+	for i := 0; i < 3; i++ {
+		e.deliver(&Event{Type: EventStart})
+	}
+
+	waitUntil(t, func() bool {
+		_, handled := fast.counts()
+		return handled == 3
+	})
+
+	close(slow.block)
+
+	waitUntil(t, func() bool {
+		_, handled := slow.counts()
+		return handled == 3
+	})
+}
+
+func TestDeadLetterQueueKeepsLastErrors(t *testing.T) {
+	q := NewDeadLetterQueue(2)
+
+	for i := 0; i < 3; i++ {
+		q.HandleEventError(&EventError{Error: fmt.Sprint(i)})
+	}
+
+	errors := q.Errors()
+	if len(errors) != 2 || errors[0].Error != "1" || errors[1].Error != "2" {
+		t.Fatalf("expected the last 2 errors received %+v", errors)
+	}
+
+	if drained := q.Drain(); len(drained) != 2 {
+		t.Fatalf("expected 2 drained errors received %d", len(drained))
+	}
+
+	if len(q.Errors()) != 0 {
+		t.Fatal("expected the queue to be empty after it is drained")
+	}
+}
+
+func TestEventRetryPolicyWithoutAttempts(t *testing.T) {
+	var (
+		e       = &Engine{ID: "test"}
+		dlq     = NewDeadLetterQueue(-1)
+		failing = &flakyHandler{failures: 100}
+	)
+
+	e.SetEventRetryPolicy(EventRetryPolicy{})
+	e.SetEventErrorHandler(dlq)
+
+	e.eventQueues = []*eventQueue{e.newEventQueue(failing)}
+	defer closeQueues(e)
+
+	e.deliver(&Event{Type: EventStart})
+
+	waitUntil(t, func() bool { return len(dlq.Errors()) == 1 })
+
+	if attempts, _ := failing.counts(); attempts != 1 {
+		t.Fatalf("expected a single attempt received %d", attempts)
+	}
+
+	if errors := dlq.Errors(); errors[0].Attempts != 1 {
+		t.Fatalf("expected the error to record 1 attempt received %d", errors[0].Attempts)
+	}
+}
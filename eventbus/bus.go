@@ -1,16 +1,179 @@
 package eventbus
 
-import "github.com/citadel/citadel"
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
 
+	"github.com/citadel/citadel"
+)
+
+// OverflowPolicy decides what happens to an event when a handler's queue is full
+type OverflowPolicy int
+
+const (
+	// Block waits for the handler to make room in its queue
+	Block OverflowPolicy = iota
+
+	// DropOldest drops the oldest queued event to make room for the new one
+	DropOldest
+
+	// DropNewest drops the new event
+	DropNewest
+)
+
+const DefaultQueueSize = 1024
+
+// Options control how events are delivered to a subscription's handler
+type Options struct {
+	// EventType is the type of events delivered to the handler, * or empty for all
+	EventType citadel.EventType
+
+	// QueueSize is the number of events queued for the handler, DefaultQueueSize if zero
+	QueueSize int
+
+	// Overflow is the policy used when the handler's queue is full
+	Overflow OverflowPolicy
+
+	// Filter further selects the events delivered to the handler
+	Filter *Filter
+
+	// Since replays the events in the bus's history from this time before delivering
+	// new events, see SetHistorySize
+	Since time.Time
+}
+
+// ErrorHandler receives the errors returned, or panics raised, by a subscription's handler
+type ErrorHandler func(s *Subscription, e *citadel.Event, err error)
+
+// Subscription is a handler registered with the bus.  Each subscription has its own
+// queue and goroutine so that a slow or failing handler does not affect the others.
+type Subscription struct {
+	ID      int
+	Handler citadel.EventHandler
+	Options Options
+
+	bus     *EventBus
+	mux     sync.Mutex
+	queue   chan *citadel.Event
+	done    chan struct{}
+	dropped uint64
+}
+
+// Unsubscribe removes the subscription from the bus and stops its delivery
+func (s *Subscription) Unsubscribe() error {
+	return s.bus.unsubscribe(s)
+}
+
+// Dropped returns the number of events dropped because the queue was full
+func (s *Subscription) Dropped() uint64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.dropped
+}
+
+func (s *Subscription) matches(e *citadel.Event, previous string) bool {
+	if t := s.Options.EventType; t != "" && t != "*" && t != e.Type {
+		return false
+	}
+
+	return s.Options.Filter == nil || s.Options.Filter.Match(e, previous)
+}
+
+func (s *Subscription) enqueue(e *citadel.Event) {
+	switch s.Options.Overflow {
+	case DropNewest:
+		select {
+		case s.queue <- e:
+		case <-s.done:
+		default:
+			s.drop()
+		}
+	case DropOldest:
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		for {
+			select {
+			case s.queue <- e:
+				return
+			case <-s.done:
+				return
+			default:
+			}
+
+			select {
+			case <-s.queue:
+				s.dropped++
+			default:
+			}
+		}
+	default:
+		select {
+		case s.queue <- e:
+		case <-s.done:
+		}
+	}
+}
+
+func (s *Subscription) drop() {
+	s.mux.Lock()
+	s.dropped++
+	s.mux.Unlock()
+}
+
+func (s *Subscription) run() {
+	for {
+		select {
+		case e := <-s.queue:
+			s.deliver(e)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Subscription) deliver(e *citadel.Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.bus.handleError(s, e, fmt.Errorf("handler panic: %v", r))
+		}
+	}()
+
+	if err := s.Handler.Handle(e); err != nil {
+		s.bus.handleError(s, e, err)
+	}
+}
+
+// EventBus dispatches events asynchronously to its subscriptions
 type EventBus struct {
-	engines  map[string]*citadel.Engine
-	handlers map[string][]citadel.EventHandler
+	mux sync.RWMutex
+
+	engines       map[string]*citadel.Engine
+	subscriptions map[int]*Subscription
+	nextID        int
+	errorHandler  ErrorHandler
+
+	// states are the last known states of containers used to match transitions
+	states *States
+
+	// history are the last events handled by the bus to replay for new subscriptions
+	history     []*historyEvent
+	historySize int
+}
+
+type historyEvent struct {
+	event    *citadel.Event
+	previous string
 }
 
 func New(engines ...*citadel.Engine) (*EventBus, error) {
 	bus := &EventBus{
-		engines:  make(map[string]*citadel.Engine),
-		handlers: make(map[string][]citadel.EventHandler),
+		engines:       make(map[string]*citadel.Engine),
+		subscriptions: make(map[int]*Subscription),
+		states:        NewStates(),
 	}
 
 	for _, e := range engines {
@@ -20,22 +183,174 @@ func New(engines ...*citadel.Engine) (*EventBus, error) {
 	return bus, nil
 }
 
+// SetErrorHandler sets the handler for errors returned by subscriptions, errors are
+// logged when no handler is set
+func (b *EventBus) SetErrorHandler(h ErrorHandler) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.errorHandler = h
+}
+
+// SetHistorySize sets the number of events kept by the bus to replay for subscriptions
+// with a Since time, no events are kept by default
+func (b *EventBus) SetHistorySize(n int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.historySize = n
+
+	if len(b.history) > n {
+		b.history = b.history[len(b.history)-n:]
+	}
+}
+
+// AddHandler subscribes the handler to events of the type with the default options
 func (b *EventBus) AddHandler(eventType string, h citadel.EventHandler) error {
-	b.handlers[eventType] = append(b.handlers[eventType], h)
+	_, err := b.Subscribe(h, &Options{EventType: citadel.EventType(eventType)})
+
+	return err
+}
+
+// Subscribe registers the handler with the bus and starts delivering events to it
+func (b *EventBus) Subscribe(h citadel.EventHandler, opts *Options) (*Subscription, error) {
+	if h == nil {
+		return nil, fmt.Errorf("handler cannot be nil")
+	}
+
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	size := opts.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.nextID++
+
+	s := &Subscription{
+		ID:      b.nextID,
+		Handler: h,
+		Options: *opts,
+		bus:     b,
+		done:    make(chan struct{}),
+	}
+
+	replay := []*citadel.Event{}
+	if !opts.Since.IsZero() {
+		for _, past := range b.history {
+			if !past.event.Time.Before(opts.Since) && s.matches(past.event, past.previous) {
+				replay = append(replay, past.event)
+			}
+		}
+	}
+
+	// the queue has room for the replayed events so that they never block or are dropped
+	s.queue = make(chan *citadel.Event, size+len(replay))
+	for _, e := range replay {
+		s.queue <- e
+	}
+
+	b.subscriptions[s.ID] = s
+
+	go s.run()
+
+	return s, nil
+}
+
+// RemoveHandler removes every subscription of the handler.  Handlers that cannot be
+// compared, see citadel.SameHandler, are only removed with Subscription.Unsubscribe.
+func (b *EventBus) RemoveHandler(h citadel.EventHandler) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	found := false
+
+	for id, s := range b.subscriptions {
+		if citadel.SameHandler(s.Handler, h) {
+			close(s.done)
+			delete(b.subscriptions, id)
+
+			found = true
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("handler is not subscribed")
+	}
+
+	return nil
+}
+
+func (b *EventBus) unsubscribe(s *Subscription) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if _, exists := b.subscriptions[s.ID]; !exists {
+		return fmt.Errorf("subscription %d does not exist", s.ID)
+	}
+
+	close(s.done)
+	delete(b.subscriptions, s.ID)
 
 	return nil
 }
 
+// Handle queues the event for every matching subscription.  It only blocks when a
+// subscription with the Block policy has a full queue.
 func (b *EventBus) Handle(event *citadel.Event) error {
-	for tpe, l := range b.handlers {
-		if tpe == "*" || tpe == event.Type {
-			for _, h := range l {
-				if err := h.Handle(event); err != nil {
-					return err
-				}
-			}
+	b.mux.Lock()
+	previous := b.states.Transition(event)
+
+	if b.historySize > 0 {
+		b.history = append(b.history, &historyEvent{event: event, previous: previous})
+
+		if len(b.history) > b.historySize {
+			b.history = b.history[len(b.history)-b.historySize:]
+		}
+	}
+
+	matching := []*Subscription{}
+	for _, s := range b.subscriptions {
+		if s.matches(event, previous) {
+			matching = append(matching, s)
 		}
 	}
+	b.mux.Unlock()
+
+	for _, s := range matching {
+		s.enqueue(event)
+	}
+
+	return nil
+}
+
+// Close removes all subscriptions from the bus
+func (b *EventBus) Close() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for id, s := range b.subscriptions {
+		close(s.done)
+		delete(b.subscriptions, id)
+	}
 
 	return nil
 }
+
+func (b *EventBus) handleError(s *Subscription, e *citadel.Event, err error) {
+	b.mux.RLock()
+	h := b.errorHandler
+	b.mux.RUnlock()
+
+	if h == nil {
+		log.Printf("eventbus: subscription %d failed to handle %s event: %s\n", s.ID, e.Type, err)
+		return
+	}
+
+	h(s, e, err)
+}
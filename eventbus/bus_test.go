@@ -0,0 +1,191 @@
+package eventbus
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+type recorder struct {
+	mux    sync.Mutex
+	events []*citadel.Event
+	block  chan struct{}
+}
+
+func (r *recorder) Handle(e *citadel.Event) error {
+	if r.block != nil {
+		<-r.block
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.events = append(r.events, e)
+
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return len(r.events)
+}
+
+type failing struct {
+	panics bool
+}
+
+func (f *failing) Handle(e *citadel.Event) error {
+	if f.panics {
+		panic("boom")
+	}
+
+	return fmt.Errorf("failed")
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFailingHandlersDoNotStopDelivery(t *testing.T) {
+	bus, _ := New()
+
+	var (
+		mux    sync.Mutex
+		errors = 0
+		r      = &recorder{}
+	)
+
+	bus.SetErrorHandler(func(s *Subscription, e *citadel.Event, err error) {
+		mux.Lock()
+		errors++
+		mux.Unlock()
+	})
+
+	bus.AddHandler("*", &failing{})
+	bus.AddHandler("*", &failing{panics: true})
+	bus.AddHandler("start", r)
+
+	bus.Handle(&citadel.Event{Type: "start"})
+	bus.Handle(&citadel.Event{Type: "die"})
+
+	waitFor(t, func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+
+		return errors == 4 && r.count() == 1
+	})
+}
+
+func TestDropNewestDoesNotBlock(t *testing.T) {
+	var (
+		bus, _ = New()
+		r      = &recorder{block: make(chan struct{})}
+	)
+
+	s, err := bus.Subscribe(r, &Options{QueueSize: 1, Overflow: DropNewest})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first event is held by the blocked handler and the second fills the queue
+	for i := 0; i < 5; i++ {
+		bus.Handle(&citadel.Event{Type: "start"})
+	}
+
+	waitFor(t, func() bool { return s.Dropped() >= 3 })
+
+	close(r.block)
+
+	waitFor(t, func() bool { return r.count() == 5-int(s.Dropped()) })
+}
+
+func TestRemoveHandler(t *testing.T) {
+	var (
+		bus, _ = New()
+		r      = &recorder{}
+	)
+
+	bus.AddHandler("*", r)
+
+	if err := bus.RemoveHandler(r); err != nil {
+		t.Fatal(err)
+	}
+
+	bus.Handle(&citadel.Event{Type: "start"})
+
+	time.Sleep(20 * time.Millisecond)
+
+	if r.count() != 0 {
+		t.Fatal("expected removed handler not to receive events")
+	}
+
+	if err := bus.RemoveHandler(r); err == nil {
+		t.Fatal("expected error removing a handler that is not subscribed")
+	}
+}
+
+type funcHandler func(*citadel.Event) error
+
+func (f funcHandler) Handle(e *citadel.Event) error {
+	return f(e)
+}
+
+func TestRemoveHandlerCannotCompare(t *testing.T) {
+	var (
+		bus, _ = New()
+		h      = funcHandler(func(*citadel.Event) error { return nil })
+	)
+
+	s, err := bus.Subscribe(h, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bus.RemoveHandler(h); err == nil {
+		t.Fatal("expected error removing a handler that cannot be compared")
+	}
+
+	if err := s.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribeReplaysHistory(t *testing.T) {
+	bus, _ := New()
+	bus.SetHistorySize(2)
+
+	start := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		bus.Handle(&citadel.Event{Type: "start", Time: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	r := &recorder{}
+	if _, err := bus.Subscribe(r, &Options{Since: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	bus.Handle(&citadel.Event{Type: "start", Time: start.Add(time.Minute)})
+
+	// only the last two events are kept in the history
+	waitFor(t, func() bool { return r.count() == 3 })
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if first := r.events[0].Time; !first.Equal(start.Add(time.Second)) {
+		t.Fatalf("expected replay to start at the second event received %s", first)
+	}
+}
@@ -0,0 +1,229 @@
+package eventbus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+// container states matched by transitions, see ContainerState
+const (
+	StateCreated = "created"
+	StateRunning = "running"
+	StatePaused  = "paused"
+	StateStopped = "stopped"
+	StateRemoved = "removed"
+)
+
+// maxStates is the number of containers whose state is kept by States
+const maxStates = 4096
+
+// Transition is a change of a container's state between two of its events
+type Transition struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// Filter selects the events delivered to a subscription.  Every non empty field has to
+// match the event and a field matches when any of its values match.
+type Filter struct {
+	// Types are the event types, citadel.EventDie for example
+	Types []citadel.EventType `json:"types,omitempty"`
+
+	// Engines are the ids of the engines the events come from
+	Engines []string `json:"engines,omitempty"`
+
+	// Images are the image references of the containers or events
+	Images []string `json:"images,omitempty"`
+
+	// ContainerTypes are the citadel types of the containers, service or batch for example
+	ContainerTypes []string `json:"container_types,omitempty"`
+
+	// Labels have to all be present on the container's image
+	Labels []string `json:"labels,omitempty"`
+
+	// Transitions are the changes of container state, an empty From or To matches any state
+	Transitions []Transition `json:"transitions,omitempty"`
+
+	// Predicate is a custom check that is run when all other fields match
+	Predicate func(*citadel.Event) bool `json:"-"`
+}
+
+// Match returns true if the event matches the filter.  previous is the state of the
+// event's container before the event, empty if it is unknown.
+func (f *Filter) Match(e *citadel.Event, previous string) bool {
+	if len(f.Types) > 0 && !containsType(f.Types, e.Type) {
+		return false
+	}
+
+	if len(f.Engines) > 0 && (e.Engine == nil || !contains(f.Engines, e.Engine.ID)) {
+		return false
+	}
+
+	image := eventImage(e)
+
+	if len(f.Images) > 0 && (image == nil || !matchesImage(f.Images, image.Name)) {
+		return false
+	}
+
+	if len(f.ContainerTypes) > 0 && (image == nil || !contains(f.ContainerTypes, image.Type)) {
+		return false
+	}
+
+	for _, l := range f.Labels {
+		if image == nil || !contains(image.Labels, l) {
+			return false
+		}
+	}
+
+	if len(f.Transitions) > 0 && (e.Container == nil || !matchesTransition(f.Transitions, previous, ContainerState(e))) {
+		return false
+	}
+
+	if f.Predicate != nil {
+		return f.Predicate(e)
+	}
+
+	return true
+}
+
+// ContainerState returns the state of the event's container after the event.  Events
+// that do not change the state, oom for example, return the state of the container.
+func ContainerState(e *citadel.Event) string {
+	switch e.Type {
+	case citadel.EventCreate:
+		return StateCreated
+	case citadel.EventStart, citadel.EventRestart, citadel.EventUnpause:
+		return StateRunning
+	case citadel.EventPause:
+		return StatePaused
+	case citadel.EventDie, citadel.EventStop:
+		return StateStopped
+	case citadel.EventDestroy:
+		return StateRemoved
+	}
+
+	if e.Container != nil {
+		return e.Container.State
+	}
+
+	return ""
+}
+
+// States tracks the last known state of containers to find the previous state of a
+// container for Filter.Match.  The state of the container seen least recently is dropped
+// once the states of maxStates containers are kept.
+type States struct {
+	mux    sync.Mutex
+	states map[string]*containerState
+}
+
+type containerState struct {
+	state string
+	seen  time.Time
+}
+
+func NewStates() *States {
+	return &States{
+		states: make(map[string]*containerState),
+	}
+}
+
+// Transition records the container state of the event and returns its previous state
+func (s *States) Transition(event *citadel.Event) string {
+	if event.Container == nil {
+		return ""
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var (
+		id       = event.Container.ID
+		previous string
+	)
+
+	if c := s.states[id]; c != nil {
+		previous = c.state
+	}
+
+	if event.Type == citadel.EventDestroy {
+		delete(s.states, id)
+
+		return previous
+	}
+
+	if _, exists := s.states[id]; !exists && len(s.states) >= maxStates {
+		s.dropOldest()
+	}
+
+	s.states[id] = &containerState{state: ContainerState(event), seen: time.Now()}
+
+	return previous
+}
+
+func (s *States) dropOldest() {
+	var (
+		oldest string
+		seen   time.Time
+	)
+
+	for id, c := range s.states {
+		if oldest == "" || c.seen.Before(seen) {
+			oldest, seen = id, c.seen
+		}
+	}
+
+	delete(s.states, oldest)
+}
+
+func eventImage(e *citadel.Event) *citadel.Image {
+	if e.Container != nil && e.Container.Image != nil {
+		return e.Container.Image
+	}
+
+	return e.Image
+}
+
+func matchesImage(images []string, name string) bool {
+	ref := citadel.ParseReference(name)
+
+	for _, i := range images {
+		if ref.Equal(citadel.ParseReference(i)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesTransition(transitions []Transition, from, to string) bool {
+	for _, t := range transitions {
+		if (t.From == "" || t.From == from) && (t.To == "" || t.To == to) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsType(types []citadel.EventType, t citadel.EventType) bool {
+	for _, tpe := range types {
+		if tpe == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+package eventbus
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/citadel/citadel"
+)
+
+func TestFilterMatch(t *testing.T) {
+	var (
+		f = &Filter{
+			Types:          []citadel.EventType{citadel.EventDie},
+			ContainerTypes: []string{"service"},
+			Labels:         []string{"zone=us-east"},
+			Images:         []string{"crosbymichael/redis:latest"},
+			Transitions:    []Transition{{From: "running", To: "stopped"}},
+		}
+		event = &citadel.Event{
+			Type: citadel.EventDie,
+			Container: &citadel.Container{
+				State: "stopped",
+				Image: &citadel.Image{
+					Name:   "crosbymichael/redis",
+					Type:   "service",
+					Labels: []string{"local", "zone=us-east"},
+				},
+			},
+		}
+	)
+
+	if !f.Match(event, "running") {
+		t.Fatal("expected event to match")
+	}
+
+	if f.Match(event, "stopped") {
+		t.Fatal("expected event not to match a different transition")
+	}
+
+	event.Container.Image.Labels = []string{"zone=us-west"}
+
+	if f.Match(event, "running") {
+		t.Fatal("expected event not to match without the label")
+	}
+}
+
+func TestFilterMatchesEventTransitions(t *testing.T) {
+	var (
+		states    = NewStates()
+		container = &citadel.Container{ID: "1", State: "running"}
+		f         = &Filter{Transitions: []Transition{{From: StatePaused, To: StateRunning}}}
+	)
+
+	for _, tpe := range []citadel.EventType{citadel.EventCreate, citadel.EventStart, citadel.EventPause} {
+		e := &citadel.Event{Type: tpe, Container: container}
+
+		if previous := states.Transition(e); f.Match(e, previous) {
+			t.Fatalf("expected %s not to match the unpause transition", tpe)
+		}
+	}
+
+	e := &citadel.Event{Type: citadel.EventUnpause, Container: container}
+	if previous := states.Transition(e); previous != StatePaused || !f.Match(e, previous) {
+		t.Fatalf("expected unpause to match the transition from %s received %s", StatePaused, previous)
+	}
+}
+
+func TestStatesAreBounded(t *testing.T) {
+	states := NewStates()
+
+	for i := 0; i < maxStates+10; i++ {
+		states.Transition(&citadel.Event{
+			Type:      citadel.EventStart,
+			Container: &citadel.Container{ID: strconv.Itoa(i)},
+		})
+	}
+
+	if n := len(states.states); n != maxStates {
+		t.Fatalf("expected %d states received %d", maxStates, n)
+	}
+}
@@ -0,0 +1,404 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+// DefaultSegmentSize is the size in bytes of a segment before the log is rotated
+const DefaultSegmentSize = 64 * 1024 * 1024
+
+const segmentExt = ".log"
+
+// Options control the rotation and retention of the log's segments
+type Options struct {
+	// SegmentSize is the size in bytes of a segment before it is rotated, DefaultSegmentSize if zero
+	SegmentSize int64
+
+	// MaxSegments is the number of segments kept, zero keeps all of them
+	MaxSegments int
+
+	// MaxAge is how long a rotated segment is kept, zero keeps them forever
+	MaxAge time.Duration
+
+	// Sync flushes every event to disk before Handle returns
+	Sync bool
+}
+
+// Position is where a subscription starts to replay the log.  Events are replayed from
+// Sequence when it is set, else from Time, and the whole log when both are empty.
+type Position struct {
+	Sequence uint64    `json:"sequence,omitempty"`
+	Time     time.Time `json:"time,omitempty"`
+}
+
+type segment struct {
+	// first is the sequence number of the first event in the segment
+	first uint64
+	path  string
+}
+
+// Log is an append only log of events stored as json lines in a directory of segments.
+// Each event is given the next sequence number when it is appended to the log.
+type Log struct {
+	mux sync.Mutex
+
+	dir      string
+	options  Options
+	sequence uint64
+	segments []*segment
+	file     *os.File
+	size     int64
+	closed   bool
+
+	// notify is closed and replaced when events are appended to wake up subscriptions
+	notify chan struct{}
+}
+
+// Open opens the log stored in dir, creating it if it does not exist
+func Open(dir string, options Options) (*Log, error) {
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:     dir,
+		options: options,
+		notify:  make(chan struct{}),
+	}
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	if len(l.segments) == 0 {
+		if err := l.rotate(); err != nil {
+			return nil, err
+		}
+
+		return l, nil
+	}
+
+	current := l.segments[len(l.segments)-1]
+
+	f, err := os.OpenFile(current.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l.file = f
+	l.size = info.Size()
+
+	return l, nil
+}
+
+// load finds the segments of the log and recovers the last sequence number
+func (l *Log) load() error {
+	matches, err := filepath.Glob(filepath.Join(l.dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range matches {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		l.segments = append(l.segments, &segment{first: first, path: path})
+	}
+
+	sort.Sort(segments(l.segments))
+
+	if len(l.segments) == 0 {
+		return nil
+	}
+
+	current := l.segments[len(l.segments)-1]
+	l.sequence = current.first - 1
+
+	last, offset, err := lastEvent(current.path)
+	if err != nil {
+		return err
+	}
+
+	if last != nil {
+		l.sequence = last.Sequence
+	}
+
+	// drop an event that was partially written when the log was last closed
+	return os.Truncate(current.path, offset)
+}
+
+// Handle appends a copy of the event to the log with the next sequence number.  The event
+// is not changed, handlers that need its sequence number receive it from Then.
+func (l *Log) Handle(e *citadel.Event) error {
+	_, err := l.append(e)
+
+	return err
+}
+
+// Then returns a handler that appends events to the log and then sends next a copy of
+// each event with its sequence number set
+func (l *Log) Then(next citadel.EventHandler) citadel.EventHandler {
+	return &sequencer{log: l, next: next}
+}
+
+type sequencer struct {
+	log  *Log
+	next citadel.EventHandler
+}
+
+// Handle appends the event once and sends a copy of it to the next handler, the same event
+// is shared by every handler of an engine so it is not changed.  Errors of the log and of
+// next are logged instead of returned so that a retried event is not appended twice.
+func (s *sequencer) Handle(e *citadel.Event) error {
+	sequence, err := s.log.append(e)
+	if err != nil {
+		log.Printf("eventlog: unable to append %s event: %s\n", e.Type, err)
+	}
+
+	c := *e
+	c.Sequence = sequence
+
+	if err := s.next.Handle(&c); err != nil {
+		log.Printf("eventlog: unable to handle %s event %d: %s\n", e.Type, sequence, err)
+	}
+
+	return nil
+}
+
+// append writes a copy of the event to the log and returns its sequence number
+func (l *Log) append(e *citadel.Event) (uint64, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.closed {
+		return 0, fmt.Errorf("event log is closed")
+	}
+
+	r := e.Record()
+	r.Sequence = l.sequence + 1
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+	data = append(data, '\n')
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+
+	if l.options.Sync {
+		if err := l.file.Sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	l.sequence = r.Sequence
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+
+	if l.size >= l.options.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return r.Sequence, err
+		}
+	}
+
+	return r.Sequence, nil
+}
+
+// Sequence returns the sequence number of the last event appended to the log
+func (l *Log) Sequence() uint64 {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return l.sequence
+}
+
+// Replay sends the events stored in the log from the position to the handler
+func (l *Log) Replay(p Position, h citadel.EventHandler) error {
+	c := newCursor(p)
+
+	_, err := c.read(l, h, nil)
+
+	return err
+}
+
+// Subscribe replays the events stored in the log from the position to the handler and
+// then continues sending it new events as they are appended to the log
+func (l *Log) Subscribe(p Position, h citadel.EventHandler) *Subscription {
+	s := &Subscription{
+		log:     l,
+		handler: h,
+		cursor:  newCursor(p),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// Close closes the log, subscriptions stop once they have read the stored events
+func (l *Log) Close() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	close(l.notify)
+
+	return l.file.Close()
+}
+
+// rotate starts a new segment and removes the segments outside of the retention
+func (l *Log) rotate() error {
+	if l.file != nil {
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	s := &segment{
+		first: l.sequence + 1,
+		path:  filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.sequence+1, segmentExt)),
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.file = f
+	l.size = 0
+	l.segments = append(l.segments, s)
+
+	l.retain()
+
+	return nil
+}
+
+// retain removes the oldest segments over MaxSegments and the segments older than MaxAge.
+// The current segment is never removed.
+func (l *Log) retain() {
+	var (
+		kept    = []*segment{}
+		current = len(l.segments) - 1
+	)
+
+	for i, s := range l.segments {
+		if i < current && l.expired(s, current-i) {
+			if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+				log.Printf("eventlog: unable to remove segment %s: %s\n", s.path, err)
+				kept = append(kept, s)
+			}
+
+			continue
+		}
+
+		kept = append(kept, s)
+	}
+
+	l.segments = kept
+}
+
+// expired returns true if the segment, with newer segments after it, is outside of the retention
+func (l *Log) expired(s *segment, newer int) bool {
+	if l.options.MaxSegments > 0 && newer >= l.options.MaxSegments {
+		return true
+	}
+
+	if l.options.MaxAge > 0 {
+		info, err := os.Stat(s.path)
+		if err != nil {
+			return os.IsNotExist(err)
+		}
+
+		return time.Since(info.ModTime()) > l.options.MaxAge
+	}
+
+	return false
+}
+
+// snapshot returns the segments, the last sequence number and the channel closed on new events
+func (l *Log) snapshot() ([]*segment, uint64, chan struct{}, bool) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	s := make([]*segment, len(l.segments))
+	copy(s, l.segments)
+
+	return s, l.sequence, l.notify, l.closed
+}
+
+// lastEvent returns the last complete event of the segment and the offset after it
+func lastEvent(path string) (*citadel.Event, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var (
+		last   *citadel.Event
+		offset int64
+		r      = bufio.NewReader(f)
+	)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return last, offset, nil
+		}
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		offset += int64(len(line))
+
+		var e *citadel.Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue
+		}
+
+		last = e
+	}
+}
+
+type segments []*segment
+
+func (s segments) Len() int           { return len(s) }
+func (s segments) Less(i, j int) bool { return s[i].first < s[j].first }
+func (s segments) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
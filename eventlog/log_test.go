@@ -0,0 +1,184 @@
+package eventlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+type recorder struct {
+	mux    sync.Mutex
+	events []*citadel.Event
+	added  chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{added: make(chan struct{}, 100)}
+}
+
+func (r *recorder) Handle(e *citadel.Event) error {
+	r.mux.Lock()
+	r.events = append(r.events, e)
+	r.mux.Unlock()
+
+	r.added <- struct{}{}
+
+	return nil
+}
+
+func (r *recorder) sequences() []uint64 {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	out := []uint64{}
+	for _, e := range r.events {
+		out = append(out, e.Sequence)
+	}
+
+	return out
+}
+
+func openTestLog(t *testing.T, options Options) (*Log, string) {
+	dir, err := ioutil.TempDir("", "eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l, dir
+}
+
+func appendEvents(t *testing.T, l *Log, start time.Time, n int) {
+	for i := 0; i < n; i++ {
+		e := &citadel.Event{
+			Type:   citadel.EventStart,
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Engine: &citadel.Engine{ID: "local"},
+		}
+
+		if err := l.Handle(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLogRotationAndReopen(t *testing.T) {
+	l, dir := openTestLog(t, Options{SegmentSize: 1, MaxSegments: 3})
+	defer os.RemoveAll(dir)
+
+	appendEvents(t, l, time.Now(), 5)
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err := Open(dir, Options{}); err != nil {
+		t.Fatal(err)
+	} else {
+		defer l.Close()
+
+		if s := l.Sequence(); s != 5 {
+			t.Fatalf("expected sequence 5 received %d", s)
+		}
+
+		r := newRecorder()
+		if err := l.Replay(Position{}, r); err != nil {
+			t.Fatal(err)
+		}
+
+		// every event is its own segment and only the last three segments are retained
+		// with the current, empty, segment being the last
+		if s := r.sequences(); len(s) != 2 || s[0] != 4 || s[1] != 5 {
+			t.Fatalf("expected events 4 and 5 received %v", s)
+		}
+	}
+}
+
+func TestLogSubscribe(t *testing.T) {
+	l, dir := openTestLog(t, Options{})
+	defer os.RemoveAll(dir)
+	defer l.Close()
+
+	start := time.Unix(1000, 0)
+	appendEvents(t, l, start, 5)
+
+	r := newRecorder()
+	s := l.Subscribe(Position{Time: start.Add(3 * time.Second)}, r)
+	defer s.Close()
+
+	appendEvents(t, l, start.Add(time.Minute), 2)
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-r.added:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events, received %v", r.sequences())
+		}
+	}
+
+	expected := []uint64{4, 5, 6, 7}
+	for i, seq := range r.sequences() {
+		if seq != expected[i] {
+			t.Fatalf("expected events %v received %v", expected, r.sequences())
+		}
+	}
+}
+
+func TestLogThenSetsSequence(t *testing.T) {
+	l, dir := openTestLog(t, Options{})
+	defer os.RemoveAll(dir)
+	defer l.Close()
+
+	var (
+		r = newRecorder()
+		h = l.Then(r)
+	)
+
+	for i := 0; i < 2; i++ {
+		if err := h.Handle(&citadel.Event{Type: citadel.EventStart, Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s := r.sequences(); len(s) != 2 || s[0] != 1 || s[1] != 2 {
+		t.Fatalf("expected the delivered events to have sequences 1 and 2 received %v", s)
+	}
+}
+
+type failingHandler struct{}
+
+func (failingHandler) Handle(e *citadel.Event) error {
+	return fmt.Errorf("unable to handle %s", e.Type)
+}
+
+func TestLogThenDoesNotChangeOrRetryEvents(t *testing.T) {
+	l, dir := openTestLog(t, Options{})
+	defer os.RemoveAll(dir)
+	defer l.Close()
+
+	var (
+		e = &citadel.Event{Type: citadel.EventStart, Time: time.Now()}
+		h = l.Then(failingHandler{})
+	)
+
+	if err := h.Handle(e); err != nil {
+		t.Fatalf("expected no error so the event is not retried received %s", err)
+	}
+
+	if e.Sequence != 0 {
+		t.Fatalf("expected the shared event to keep sequence 0 received %d", e.Sequence)
+	}
+
+	if s := l.Sequence(); s != 1 {
+		t.Fatalf("expected the event to be appended once received sequence %d", s)
+	}
+}
@@ -0,0 +1,187 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+// Subscription sends the events of a log to a handler, see Log.Subscribe
+type Subscription struct {
+	log     *Log
+	handler citadel.EventHandler
+	cursor  *cursor
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Close stops sending events to the handler
+func (s *Subscription) Close() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+
+	<-s.done
+}
+
+func (s *Subscription) run() {
+	defer close(s.done)
+
+	for {
+		notify, err := s.cursor.read(s.log, s.handler, s.stop)
+		if err != nil {
+			log.Printf("eventlog: unable to read events: %s\n", err)
+			return
+		}
+
+		if notify == nil {
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// cursor is the position of a reader in the log
+type cursor struct {
+	next  uint64
+	since time.Time
+
+	// path and offset are where the reader stopped in the log's segments
+	path   string
+	offset int64
+}
+
+func newCursor(p Position) *cursor {
+	c := &cursor{next: p.Sequence}
+
+	if p.Sequence == 0 {
+		c.since = p.Time
+	}
+
+	return c
+}
+
+// read sends the events stored in the log after the cursor to the handler.  It returns
+// the channel closed when new events are appended, nil if the log is closed or the reader
+// is stopped.
+func (c *cursor) read(l *Log, h citadel.EventHandler, stop chan struct{}) (chan struct{}, error) {
+	segments, sequence, notify, closed := l.snapshot()
+
+	for i := c.start(segments); i < len(segments); i++ {
+		s := segments[i]
+
+		if s.path != c.path {
+			c.path = s.path
+			c.offset = 0
+		}
+
+		if err := c.readSegment(s, sequence, h, stop); err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-stop:
+			return nil, nil
+		default:
+		}
+	}
+
+	if closed {
+		return nil, nil
+	}
+
+	return notify, nil
+}
+
+// start returns the index of the segment the cursor continues reading from
+func (c *cursor) start(segments []*segment) int {
+	for i, s := range segments {
+		if s.path == c.path {
+			return i
+		}
+	}
+
+	// the segment the cursor was reading was removed, or it has not started yet
+	c.path = ""
+
+	start := 0
+	for i, s := range segments {
+		if s.first <= c.next {
+			start = i
+		}
+	}
+
+	return start
+}
+
+func (c *cursor) readSegment(s *segment, sequence uint64, h citadel.EventHandler, stop chan struct{}) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(c.offset, 0); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// an incomplete line is an event that is still being written
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var e *citadel.Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			log.Printf("eventlog: skipping invalid event in %s: %s\n", s.path, err)
+			c.offset += int64(len(line))
+			continue
+		}
+
+		if e.Sequence > sequence {
+			return nil
+		}
+
+		c.offset += int64(len(line))
+
+		if e.Sequence < c.next || e.Time.Before(c.since) {
+			continue
+		}
+
+		c.next = e.Sequence + 1
+		c.since = time.Time{}
+
+		if err := h.Handle(e); err != nil {
+			log.Printf("eventlog: unable to handle event %d: %s\n", e.Sequence, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package citadel
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ExecSession is a process started inside of a running container.  Reads return the
+// process output, multiplexed like container logs unless the session has a tty, and
+// writes are sent to the process stdin.
+type ExecSession struct {
+	// ID is the docker exec id
+	ID string `json:"id,omitempty"`
+
+	// Tty is true when the process was given a tty
+	Tty bool `json:"tty,omitempty"`
+
+	engine *Engine
+	conn   net.Conn
+	r      *bufio.Reader
+}
+
+func (s *ExecSession) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *ExecSession) Write(p []byte) (int, error) {
+	return s.conn.Write(p)
+}
+
+// CloseWrite closes the process stdin while still reading its output
+func (s *ExecSession) CloseWrite() error {
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	if cw, ok := s.conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
+
+func (s *ExecSession) Close() error {
+	return s.conn.Close()
+}
+
+// Output returns the demultiplexed stdout and stderr of the process
+func (s *ExecSession) Output() *LogStream {
+	return &LogStream{
+		body: s,
+		r:    s.r,
+		tty:  s.Tty,
+	}
+}
+
+// ExitCode waits up to timeout for the process to exit and returns its exit code
+func (s *ExecSession) ExitCode(timeout time.Duration) (int, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		resp, err := s.engine.request("GET", fmt.Sprintf("/exec/%s/json", s.ID), nil, nil)
+		if err != nil {
+			return -1, err
+		}
+
+		var info struct {
+			Running  bool
+			ExitCode int
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&info)
+		resp.Body.Close()
+
+		if err != nil {
+			return -1, err
+		}
+
+		if !info.Running {
+			return info.ExitCode, nil
+		}
+
+		if time.Now().After(deadline) {
+			return -1, fmt.Errorf("exec %s did not exit within %s", s.ID, timeout)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Exec starts the command inside of the container and returns the session attached to
+// its stdin, stdout and stderr
+func (e *Engine) Exec(c *Container, cmd []string, tty bool) (*ExecSession, error) {
+	config, err := json.Marshal(map[string]interface{}{
+		"AttachStdin":  true,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Tty":          tty,
+		"Cmd":          cmd,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.request("POST", fmt.Sprintf("/containers/%s/exec", c.ID), nil, bytes.NewReader(config))
+	if err != nil {
+		return nil, err
+	}
+
+	var created struct {
+		Id string
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := json.Marshal(map[string]interface{}{
+		"Detach": false,
+		"Tty":    tty,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := e.newRequest("POST", fmt.Sprintf("/exec/%s/start", created.Id), nil, bytes.NewReader(start))
+	if err != nil {
+		return nil, err
+	}
+
+	conn, r, err := e.hijack(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExecSession{
+		ID:     created.Id,
+		Tty:    tty,
+		engine: e,
+		conn:   conn,
+		r:      r,
+	}, nil
+}
+
+// hijack sends the request on a new connection to the engine and returns the connection
+// for raw use once docker has accepted the request
+func (e *Engine) hijack(req *http.Request) (net.Conn, *bufio.Reader, error) {
+	conn, err := e.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	r := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, nil, fmt.Errorf("unable to hijack connection: %s", resp.Status)
+	}
+
+	return conn, r, nil
+}
+
+// dial opens a raw connection to the engine's docker API.  The address is taken from the
+// engine's client, like newRequest, so that addresses without a scheme work as well.
+func (e *Engine) dial() (net.Conn, error) {
+	if e.client == nil {
+		return nil, ErrNotConnected
+	}
+
+	// the client's url does not keep the path of unix sockets
+	if strings.HasPrefix(e.Addr, "unix://") {
+		return net.Dial("unix", strings.TrimPrefix(e.Addr, "unix://"))
+	}
+
+	u := e.client.URL
+
+	config := e.tlsConfig
+	if config == nil && u.Scheme == "https" {
+		config = &tls.Config{}
+		if t, ok := e.client.HTTPClient.Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+			config = t.TLSClientConfig
+		}
+	}
+
+	if config != nil {
+		return tls.Dial("tcp", u.Host, config)
+	}
+
+	return net.Dial("tcp", u.Host)
+}
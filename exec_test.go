@@ -0,0 +1,124 @@
+package citadel
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/samalba/dockerclient"
+)
+
+func TestExecHijacksConnection(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/containers/1/exec", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Id": "abc"}`)
+	})
+
+	mux.HandleFunc("/exec/abc/start", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "tcp" {
+			http.Error(w, "expected an upgrade", http.StatusBadRequest)
+			return
+		}
+
+		// the start config is read before the connection is hijacked
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			t.Error(err)
+			return
+		}
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(buf, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		buf.Flush()
+
+		// echo the process stdin back on stdout
+		line, err := bufio.NewReader(buf).ReadString('\n')
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		conn.Write(frame(1, line))
+	})
+
+	mux.HandleFunc("/exec/abc/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Running": false, "ExitCode": 3}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// addresses are usually given without a scheme
+	e := &Engine{ID: "test", Addr: u.Host}
+	e.SetClient(&dockerclient.DockerClient{URL: u, HTTPClient: http.DefaultClient})
+
+	session, err := e.Exec(&Container{ID: "1"}, []string{"cat"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if session.ID != "abc" {
+		t.Fatalf("expected exec id abc received %s", session.ID)
+	}
+
+	if _, err := session.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := session.Output().Copy(&stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout.String() != "ping\n" {
+		t.Fatalf("expected the process output ping received %q", stdout.String())
+	}
+
+	code, err := session.ExitCode(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != 3 {
+		t.Fatalf("expected exit code 3 received %d", code)
+	}
+}
+
+func TestExecExitCodeTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Running": true}`)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Engine{ID: "test", Addr: u.Host}
+	e.SetClient(&dockerclient.DockerClient{URL: u, HTTPClient: http.DefaultClient})
+
+	session := &ExecSession{ID: "abc", engine: e}
+
+	if _, err := session.ExitCode(200 * time.Millisecond); err == nil {
+		t.Fatal("expected an error for a process that does not exit")
+	}
+}
@@ -0,0 +1,443 @@
+package gc
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/citadel/citadel"
+	"github.com/citadel/citadel/cluster"
+)
+
+const (
+	ContainerDeletion = "container"
+	ImageDeletion     = "image"
+)
+
+// Policy controls what the garbage collector removes from the engines.  Only containers
+// created by citadel are collected.
+type Policy struct {
+	// KeepExited is the number of exited containers kept for each image, zero keeps all
+	KeepExited int `json:"keep_exited,omitempty"`
+
+	// ExitedTTL is how long exited containers are kept, zero keeps them forever
+	ExitedTTL time.Duration `json:"exited_ttl,omitempty"`
+
+	// ImageTTL is how long images are kept after their last use or, for images that were
+	// never used, after the collector first saw them on the engine.  Zero keeps them
+	// forever.  Images used by a citadel service anywhere in the cluster and images kept
+	// by a pre-pull policy are always kept.
+	ImageTTL time.Duration `json:"image_ttl,omitempty"`
+
+	// DryRun reports what would be removed without removing anything
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// Deletion is a container or image removed by the garbage collector
+type Deletion struct {
+	Type   string          `json:"type,omitempty"`
+	Engine *citadel.Engine `json:"engine,omitempty"`
+	ID     string          `json:"id,omitempty"`
+	Image  string          `json:"image,omitempty"`
+	Reason string          `json:"reason,omitempty"`
+	DryRun bool            `json:"dry_run,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Collector removes exited containers and unused images from the engines of a cluster
+type Collector struct {
+	mux sync.Mutex
+
+	cluster *cluster.Cluster
+	handler citadel.EventHandler
+
+	// seen is when each image was first seen, by engine id and image id
+	seen map[string]map[string]time.Time
+}
+
+// New returns a collector for the cluster that publishes an event to the handler for
+// every deletion.  The handler can be nil.
+func New(c *cluster.Cluster, handler citadel.EventHandler) *Collector {
+	return &Collector{
+		cluster: c,
+		handler: handler,
+		seen:    make(map[string]map[string]time.Time),
+	}
+}
+
+// Run collects with the policy every interval until stop is closed
+func (g *Collector) Run(p Policy, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := g.Collect(p); err != nil {
+			log.Printf("gc: %s\n", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect runs a single collection across all engines with the policy and returns what
+// was removed, or what would be removed on a dry run
+func (g *Collector) Collect(p Policy) ([]*Deletion, error) {
+	var (
+		now = time.Now()
+		out = []*Deletion{}
+	)
+
+	all, err := g.cluster.ListContainers(true)
+	if err != nil {
+		return nil, err
+	}
+
+	services := serviceImages(all)
+
+	g.forget(g.cluster.Engines())
+
+	for _, e := range g.cluster.Engines() {
+		containers, err := e.ListContainers(true)
+		if err != nil {
+			return nil, err
+		}
+
+		removed := make(map[string]bool)
+
+		for _, d := range exitedContainers(containers, p, now) {
+			d.Engine = e
+			out = append(out, d)
+
+			if p.DryRun {
+				removed[d.ID] = true
+				continue
+			}
+
+			if err := e.Remove(&citadel.Container{ID: d.ID, Engine: e}); err != nil {
+				d.Error = err.Error()
+				continue
+			}
+
+			removed[d.ID] = true
+			g.publish(d, now)
+		}
+
+		if p.ImageTTL == 0 {
+			continue
+		}
+
+		images, err := e.EngineImages()
+		if err != nil {
+			return nil, err
+		}
+
+		remaining := []*citadel.Container{}
+		for _, c := range containers {
+			if !removed[c.ID] {
+				remaining = append(remaining, c)
+			}
+		}
+
+		imagesRemoved := false
+
+		var (
+			seen = g.firstSeen(e, images, now)
+			kept = services.with(g.cluster.PrePullImages(e))
+		)
+
+		for _, d := range unusedImages(images, remaining, kept, seen, p, now) {
+			d.Engine = e
+			out = append(out, d)
+
+			if p.DryRun {
+				continue
+			}
+
+			if err := e.RemoveImage(d.Image); err != nil {
+				d.Error = err.Error()
+				continue
+			}
+
+			imagesRemoved = true
+			g.publish(d, now)
+		}
+
+		// images kept by the pre-pull policies are pulled back if they were removed
+		if imagesRemoved {
+			go g.cluster.ApplyPrePullPolicies(e)
+		}
+	}
+
+	return out, nil
+}
+
+// firstSeen records the images of the engine that were not seen before and returns when
+// each image was first seen.  Images that are no longer on the engine are forgotten.
+func (g *Collector) firstSeen(e *citadel.Engine, images []*citadel.EngineImage, now time.Time) map[string]time.Time {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	var (
+		previous = g.seen[e.ID]
+		out      = make(map[string]time.Time)
+	)
+
+	for _, i := range images {
+		first, exists := previous[i.ID]
+		if !exists {
+			first = now
+		}
+
+		out[i.ID] = first
+	}
+
+	g.seen[e.ID] = out
+
+	return out
+}
+
+// forget drops the images seen on engines that are no longer in the cluster
+func (g *Collector) forget(engines []*citadel.Engine) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	current := make(map[string]bool)
+	for _, e := range engines {
+		current[e.ID] = true
+	}
+
+	for id := range g.seen {
+		if !current[id] {
+			delete(g.seen, id)
+		}
+	}
+}
+
+func (g *Collector) publish(d *Deletion, now time.Time) {
+	if g.handler == nil {
+		return
+	}
+
+	event := &citadel.Event{
+		Type:   citadel.EventGCImage,
+		Engine: d.Engine,
+		Time:   now,
+		Image:  &citadel.Image{Name: d.Image},
+		Reason: d.Reason,
+	}
+
+	if d.Type == ContainerDeletion {
+		event.Type = citadel.EventGCContainer
+		event.Container = &citadel.Container{
+			ID:     d.ID,
+			Engine: d.Engine,
+			Image:  event.Image,
+		}
+	}
+
+	if err := g.handler.Handle(event); err != nil {
+		log.Printf("gc: unable to publish event for %s: %s\n", d.ID, err)
+	}
+}
+
+// exitedContainers returns the exited citadel containers that the policy removes
+func exitedContainers(containers []*citadel.Container, p Policy, now time.Time) []*Deletion {
+	var (
+		out     = []*Deletion{}
+		byImage = make(map[string][]*citadel.Container)
+	)
+
+	for _, c := range containers {
+		if c.State == "running" || c.Image.Type == "" {
+			continue
+		}
+
+		// containers that were created but never started have not exited and may be about
+		// to be started
+		if c.FinishedAt.IsZero() {
+			continue
+		}
+
+		name := citadel.ParseReference(c.Image.Name).String()
+		byImage[name] = append(byImage[name], c)
+	}
+
+	for name, exited := range byImage {
+		sort.Sort(byFinished(exited))
+
+		for i, c := range exited {
+			d := &Deletion{
+				Type:   ContainerDeletion,
+				ID:     c.ID,
+				Image:  name,
+				DryRun: p.DryRun,
+			}
+
+			switch {
+			case p.ExitedTTL > 0 && now.Sub(c.FinishedAt) > p.ExitedTTL:
+				d.Reason = fmt.Sprintf("exited more than %s ago", p.ExitedTTL)
+			case p.KeepExited > 0 && i >= p.KeepExited:
+				d.Reason = fmt.Sprintf("more than %d exited containers for image", p.KeepExited)
+			default:
+				continue
+			}
+
+			out = append(out, d)
+		}
+	}
+
+	return out
+}
+
+// unusedImages returns the tags of the images that were not used within the policy's ttl.
+// Containers are matched to images by id so that a container created from a digest also
+// keeps the tags of its image.
+func unusedImages(images []*citadel.EngineImage, containers []*citadel.Container, kept *keep, seen map[string]time.Time, p Policy, now time.Time) []*Deletion {
+	out := []*Deletion{}
+
+	for _, i := range images {
+		if kept.has(i) {
+			continue
+		}
+
+		lastUsed, exists := seen[i.ID]
+		if !exists {
+			lastUsed = now
+		}
+
+		for _, c := range containers {
+			if !uses(c, i) {
+				continue
+			}
+
+			if c.State == "running" {
+				lastUsed = now
+			} else if c.FinishedAt.After(lastUsed) {
+				lastUsed = c.FinishedAt
+			}
+		}
+
+		if now.Sub(lastUsed) <= p.ImageTTL {
+			continue
+		}
+
+		for _, tag := range i.Tags {
+			if tag == "<none>:<none>" {
+				continue
+			}
+
+			out = append(out, &Deletion{
+				Type:   ImageDeletion,
+				ID:     i.ID,
+				Image:  tag,
+				Reason: fmt.Sprintf("unused for more than %s", p.ImageTTL),
+				DryRun: p.DryRun,
+			})
+		}
+	}
+
+	return out
+}
+
+// uses returns true if the container was created from the image, containers without an
+// image id are matched on the image's tags and digests
+func uses(c *citadel.Container, i *citadel.EngineImage) bool {
+	if c.ImageID != "" {
+		return c.ImageID == i.ID
+	}
+
+	return matches(i, citadel.ParseReference(c.Image.Name))
+}
+
+// matches returns true if one of the image's tags or digests is the reference
+func matches(i *citadel.EngineImage, ref *citadel.Reference) bool {
+	for _, name := range append(append([]string{}, i.Tags...), i.Digests...) {
+		if ref.Equal(citadel.ParseReference(name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keep is the set of images that are never collected
+type keep struct {
+	ids        map[string]bool
+	references []*citadel.Reference
+}
+
+// with returns a copy of the set that also keeps the images
+func (k *keep) with(images []*citadel.Image) *keep {
+	out := &keep{
+		ids:        k.ids,
+		references: append([]*citadel.Reference{}, k.references...),
+	}
+
+	for _, i := range images {
+		out.references = append(out.references, citadel.ParseReference(i.Name))
+	}
+
+	return out
+}
+
+// has returns true if the image is kept by its id or one of its tags or digests
+func (k *keep) has(i *citadel.EngineImage) bool {
+	if k.ids[i.ID] {
+		return true
+	}
+
+	for _, ref := range k.references {
+		if matches(i, ref) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serviceImages returns the images used by citadel services anywhere in the cluster
+func serviceImages(containers []*citadel.Container) *keep {
+	var (
+		out  = &keep{ids: make(map[string]bool)}
+		refs = make(map[string]bool)
+	)
+
+	for _, c := range containers {
+		if c.Image.Type != "service" && c.Image.Service == "" {
+			continue
+		}
+
+		if c.ImageID != "" {
+			out.ids[c.ImageID] = true
+		}
+
+		ref := citadel.ParseReference(c.Image.Name)
+		if !refs[ref.String()] {
+			refs[ref.String()] = true
+			out.references = append(out.references, ref)
+		}
+	}
+
+	return out
+}
+
+// byFinished sorts containers with the most recently exited first
+type byFinished []*citadel.Container
+
+func (b byFinished) Len() int {
+	return len(b)
+}
+
+func (b byFinished) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+func (b byFinished) Less(i, j int) bool {
+	return b[i].FinishedAt.After(b[j].FinishedAt)
+}
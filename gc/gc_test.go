@@ -0,0 +1,142 @@
+package gc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+func TestExitedContainers(t *testing.T) {
+	var (
+		now   = time.Now()
+		image = &citadel.Image{Name: "crosbymichael/redis", Type: "batch"}
+		p     = Policy{KeepExited: 2, ExitedTTL: 24 * time.Hour}
+	)
+
+	containers := []*citadel.Container{
+		{ID: "running", Image: image, State: "running"},
+		{ID: "created", Image: image, State: "stopped"},
+		{ID: "1", Image: image, State: "stopped", FinishedAt: now.Add(-1 * time.Hour)},
+		{ID: "2", Image: image, State: "stopped", FinishedAt: now.Add(-2 * time.Hour)},
+		{ID: "3", Image: image, State: "stopped", FinishedAt: now.Add(-3 * time.Hour)},
+		{ID: "old", Image: &citadel.Image{Name: "busybox", Type: "batch"}, State: "stopped", FinishedAt: now.Add(-48 * time.Hour)},
+		{ID: "external", Image: &citadel.Image{Name: "busybox"}, State: "stopped", FinishedAt: now.Add(-48 * time.Hour)},
+	}
+
+	removed := make(map[string]bool)
+	for _, d := range exitedContainers(containers, p, now) {
+		removed[d.ID] = true
+	}
+
+	if len(removed) != 2 || !removed["3"] || !removed["old"] {
+		t.Fatalf("expected containers 3 and old to be removed received %v", removed)
+	}
+}
+
+func TestUnusedImagesKeepsServices(t *testing.T) {
+	var (
+		now    = time.Now()
+		p      = Policy{ImageTTL: 24 * time.Hour}
+		images = []*citadel.EngineImage{
+			{ID: "a", Tags: []string{"redis:latest"}},
+			{ID: "b", Tags: []string{"busybox:latest"}},
+			{ID: "c", Tags: []string{"nginx:latest"}},
+		}
+		seen = map[string]time.Time{
+			"a": now.Add(-72 * time.Hour),
+			"b": now.Add(-72 * time.Hour),
+			"c": now.Add(-72 * time.Hour),
+		}
+		containers = []*citadel.Container{
+			{ID: "1", Image: &citadel.Image{Name: "nginx"}, State: "stopped", FinishedAt: now.Add(-1 * time.Hour)},
+		}
+		services = serviceImages([]*citadel.Container{
+			{ID: "2", Image: &citadel.Image{Name: "redis", Type: "service"}},
+		})
+	)
+
+	d := unusedImages(images, containers, services, seen, p, now)
+	if len(d) != 1 || d[0].Image != "busybox:latest" {
+		t.Fatalf("expected only busybox to be removed received %v", d)
+	}
+}
+
+func TestUnusedImagesAgesFromFirstSeen(t *testing.T) {
+	var (
+		now    = time.Now()
+		p      = Policy{ImageTTL: 24 * time.Hour}
+		images = []*citadel.EngineImage{
+			{ID: "old", Tags: []string{"redis:latest"}, Created: now.Add(-720 * time.Hour)},
+			{ID: "new", Tags: []string{"busybox:latest"}, Created: now.Add(-720 * time.Hour)},
+		}
+		seen = map[string]time.Time{
+			"old": now.Add(-48 * time.Hour),
+			"new": now.Add(-1 * time.Hour),
+		}
+	)
+
+	d := unusedImages(images, nil, &keep{}, seen, p, now)
+	if len(d) != 1 || d[0].Image != "redis:latest" {
+		t.Fatalf("expected only the image seen 48 hours ago to be removed received %v", d)
+	}
+}
+
+func TestUnusedImagesMatchesContainersByID(t *testing.T) {
+	var (
+		now    = time.Now()
+		p      = Policy{ImageTTL: 24 * time.Hour}
+		images = []*citadel.EngineImage{
+			{ID: "a", Tags: []string{"redis:latest"}, Digests: []string{"redis@sha256:abc"}},
+		}
+		seen       = map[string]time.Time{"a": now.Add(-72 * time.Hour)}
+		containers = []*citadel.Container{
+			{ID: "1", ImageID: "a", Image: &citadel.Image{Name: "redis@sha256:abc"}, State: "running"},
+		}
+	)
+
+	if d := unusedImages(images, containers, &keep{}, seen, p, now); len(d) != 0 {
+		t.Fatalf("expected the tag of the running digest to be kept received %v", d)
+	}
+}
+
+func TestUnusedImagesKeepsPrePulledImages(t *testing.T) {
+	var (
+		now    = time.Now()
+		p      = Policy{ImageTTL: 24 * time.Hour}
+		images = []*citadel.EngineImage{
+			{ID: "a", Tags: []string{"redis:latest"}},
+			{ID: "b", Tags: []string{"busybox:latest"}},
+		}
+		seen = map[string]time.Time{
+			"a": now.Add(-72 * time.Hour),
+			"b": now.Add(-72 * time.Hour),
+		}
+		kept = (&keep{}).with([]*citadel.Image{{Name: "redis"}})
+	)
+
+	d := unusedImages(images, nil, kept, seen, p, now)
+	if len(d) != 1 || d[0].Image != "busybox:latest" {
+		t.Fatalf("expected only busybox to be removed received %v", d)
+	}
+}
+
+func TestCollectorFirstSeen(t *testing.T) {
+	var (
+		g      = New(nil, nil)
+		e      = &citadel.Engine{ID: "local"}
+		start  = time.Now()
+		images = []*citadel.EngineImage{{ID: "a"}}
+	)
+
+	g.firstSeen(e, images, start)
+
+	seen := g.firstSeen(e, append(images, &citadel.EngineImage{ID: "b"}), start.Add(time.Hour))
+	if !seen["a"].Equal(start) || !seen["b"].Equal(start.Add(time.Hour)) {
+		t.Fatalf("expected a to be seen at the start and b an hour later received %v", seen)
+	}
+
+	if seen = g.firstSeen(e, nil, start.Add(2*time.Hour)); len(seen) != 0 {
+		t.Fatalf("expected removed images to be forgotten received %v", seen)
+	}
+}
@@ -1,6 +1,9 @@
 package citadel
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Image is a template for running a docker container
 type Image struct {
@@ -13,6 +16,9 @@ type Image struct {
 	// Memory is the amount of memory in MB for the container
 	Memory float64 `json:"memory,omitempty"`
 
+	// Disk is the amount of disk in MB that the container requires
+	Disk float64 `json:"disk,omitempty"`
+
 	// Envionrment is the environment vars to set on the container
 	Environment map[string]string `json:"environment,omitempty"`
 
@@ -31,12 +37,26 @@ type Image struct {
 	// Labels are matched with constraints on the engines
 	Labels []string `json:"labels,omitempty"`
 
-	// BindPorts ensures that the container has exclusive access to the specified ports
+	// Owner is the user or team that owns the container
+	Owner string `json:"owner,omitempty"`
+
+	// Service is the name of the service that the container belongs to
+	Service string `json:"service,omitempty"`
+
+	// BindPorts ensures that the container has exclusive access to the specified ports.
+	// Ports without a host port are assigned one by the cluster's port allocator
 	BindPorts []*Port `json:"bind_ports,omitempty"`
 
+	// Volumes are the bind mounts and docker volumes to mount into the container
+	Volumes []*Volume `json:"volumes,omitempty"`
+
 	// UserData is user defined data that is passed to the container
 	UserData map[string][]string `json:"user_data,omitempty"`
 
+	// UserDataDelivery is how the user data is passed to the container: labels, files
+	// or metadata.  Labels are used by default
+	UserDataDelivery string `json:"user_data_delivery,omitempty"`
+
 	// Links are mappings to other containers running on the same engine
 	Links map[string]string `json:"links,omitempty"`
 
@@ -51,6 +71,78 @@ type Image struct {
 
 	// ContainerName is the name set to the container
 	ContainerName string `json:"container_name,omitempty"`
+
+	// Entrypoint overrides the entrypoint of the image
+	Entrypoint []string `json:"entrypoint,omitempty"`
+
+	// WorkingDir is the working directory for the container's process
+	WorkingDir string `json:"working_dir,omitempty"`
+
+	// User is the user that the container's process runs as
+	User string `json:"user,omitempty"`
+
+	// Privileged gives the container extended privileges on the engine
+	Privileged bool `json:"privileged,omitempty"`
+
+	// CapAdd are the kernel capabilities to add to the container
+	CapAdd []string `json:"cap_add,omitempty"`
+
+	// CapDrop are the kernel capabilities to drop from the container
+	CapDrop []string `json:"cap_drop,omitempty"`
+
+	// Dns are the dns servers for the container to use
+	Dns []string `json:"dns,omitempty"`
+
+	// ExtraHosts are host:ip mappings added to the container's /etc/hosts
+	ExtraHosts []string `json:"extra_hosts,omitempty"`
+
+	// Ulimits are the resource limits set on the container's process
+	Ulimits []*Ulimit `json:"ulimits,omitempty"`
+
+	// Cpuset are the cpus that the container is allowed to run on, 0-3 or 0,1
+	Cpuset string `json:"cpuset,omitempty"`
+
+	// MemorySwap is the total amount of memory and swap in MB, -1 for unlimited swap
+	MemorySwap float64 `json:"memory_swap,omitempty"`
+
+	// LogConfig is the log driver and its options for the container
+	LogConfig *LogConfig `json:"log_config,omitempty"`
+
+	// Devices are the host devices to add to the container
+	Devices []*Device `json:"devices,omitempty"`
+
+	// StopSignal is the signal sent to stop the container, SIGTERM by default
+	StopSignal string `json:"stop_signal,omitempty"`
+
+	// StopTimeout is the number of seconds the container has to exit after the stop
+	// signal before it is killed
+	StopTimeout int `json:"stop_timeout,omitempty"`
+}
+
+type Ulimit struct {
+	Name string `json:"name,omitempty"`
+	Soft int64  `json:"soft,omitempty"`
+	Hard int64  `json:"hard,omitempty"`
+}
+
+type LogConfig struct {
+	Type   string            `json:"type,omitempty"`
+	Config map[string]string `json:"config,omitempty"`
+}
+
+type Device struct {
+	PathOnHost        string `json:"path_on_host,omitempty"`
+	PathInContainer   string `json:"path_in_container,omitempty"`
+	CgroupPermissions string `json:"cgroup_permissions,omitempty"`
+}
+
+// EngineImage is an image stored on an engine
+type EngineImage struct {
+	ID      string    `json:"id,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+	Digests []string  `json:"digests,omitempty"`
+	Created time.Time `json:"created,omitempty"`
+	Size    int64     `json:"size,omitempty"`
 }
 
 type RestartPolicy struct {
@@ -0,0 +1,149 @@
+package citadel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/samalba/dockerclient"
+)
+
+// docker labels used to store citadel's metadata on a container
+const (
+	LabelType     = "citadel.type"
+	LabelLabels   = "citadel.labels"
+	LabelOwner    = "citadel.owner"
+	LabelService  = "citadel.service"
+	LabelDisk     = "citadel.disk"
+	LabelSpecHash = "citadel.spec-hash"
+	LabelImage    = "citadel.image"
+)
+
+// metadata is the citadel specific information stored with a container
+type metadata struct {
+	Type     string
+	Labels   []string
+	Owner    string
+	Service  string
+	Disk     float64
+	SpecHash string
+
+	// Image is the original spec that the container was created from
+	Image *Image
+}
+
+// SpecHash returns a hash of the image's spec so that containers started from the same
+// spec can be matched
+func (i *Image) SpecHash() (string, error) {
+	data, err := json.Marshal(i)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// containerLabels returns the docker labels for a container created from the image
+func containerLabels(i *Image) (map[string]string, error) {
+	labels, err := json.Marshal(i.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := json.Marshal(storedSpec(i))
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := i.SpecHash()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		LabelType:     i.Type,
+		LabelLabels:   string(labels),
+		LabelOwner:    i.Owner,
+		LabelService:  i.Service,
+		LabelDisk:     strconv.FormatFloat(i.Disk, 'f', -1, 64),
+		LabelSpecHash: hash,
+		LabelImage:    string(spec),
+	}, nil
+}
+
+// storedSpec returns the copy of the image that is stored in the container's labels.  The
+// environment is already part of the container's config and the user data of the files
+// and metadata modes is delivered privately so neither is exposed in a label.
+func storedSpec(i *Image) *Image {
+	stored := *i
+	stored.Environment = nil
+
+	if i.UserDataMode() != UserDataLabels {
+		stored.UserData = nil
+	}
+
+	return &stored
+}
+
+// parseMetadata returns citadel's metadata and the user's environment for the container.
+// Containers created by older versions of citadel store the metadata in the environment
+// as _citadel_* vars which are read when the container has no citadel labels.
+func parseMetadata(config *dockerclient.ContainerConfig) (*metadata, map[string]string) {
+	var (
+		m      = &metadata{Labels: []string{}}
+		legacy = &metadata{Labels: []string{}}
+		env    = make(map[string]string)
+	)
+
+	for _, e := range config.Env {
+		var (
+			parts = strings.SplitN(e, "=", 2)
+			k, v  = parts[0], ""
+		)
+
+		if len(parts) == 2 {
+			v = parts[1]
+		}
+
+		switch k {
+		case "_citadel_type":
+			legacy.Type = v
+		case "_citadel_labels":
+			if v != "" {
+				legacy.Labels = strings.Split(v, ",")
+			}
+		case "HOME", "DEBIAN_FRONTEND", "PATH", userDataEnv:
+			continue
+		default:
+			env[k] = v
+		}
+	}
+
+	if _, exists := config.Labels[LabelType]; !exists {
+		return legacy, env
+	}
+
+	m.Type = config.Labels[LabelType]
+	m.Owner = config.Labels[LabelOwner]
+	m.Service = config.Labels[LabelService]
+	m.SpecHash = config.Labels[LabelSpecHash]
+	m.Disk, _ = strconv.ParseFloat(config.Labels[LabelDisk], 64)
+
+	if raw := config.Labels[LabelLabels]; raw != "" {
+		json.Unmarshal([]byte(raw), &m.Labels)
+	}
+
+	if raw := config.Labels[LabelImage]; raw != "" {
+		var i *Image
+		if err := json.Unmarshal([]byte(raw), &i); err == nil {
+			i.Environment = env
+			m.Image = i
+		}
+	}
+
+	return m, env
+}
@@ -0,0 +1,128 @@
+package citadel
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/samalba/dockerclient"
+)
+
+func TestParseMetadataFromLabels(t *testing.T) {
+	labels, err := containerLabels(&Image{
+		Type:    "service",
+		Labels:  []string{"zone=us-east,rack=1", "local"},
+		Owner:   "ops",
+		Service: "redis",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, env := parseMetadata(&dockerclient.ContainerConfig{
+		Env:    []string{"DSN=user=citadel password=secret", "NOVALUE", "PATH=/bin"},
+		Labels: labels,
+	})
+
+	if m.Type != "service" || m.Owner != "ops" || m.Service != "redis" {
+		t.Fatalf("unexpected metadata %+v", m)
+	}
+
+	if len(m.Labels) != 2 || m.Labels[0] != "zone=us-east,rack=1" {
+		t.Fatalf("expected labels to be decoded losslessly received %v", m.Labels)
+	}
+
+	if env["DSN"] != "user=citadel password=secret" {
+		t.Fatalf("expected env value to keep its = received %q", env["DSN"])
+	}
+
+	if v, exists := env["NOVALUE"]; !exists || v != "" {
+		t.Fatalf("expected env entry without a value to be kept")
+	}
+
+	if _, exists := env["PATH"]; exists {
+		t.Fatalf("expected PATH to be removed from the environment")
+	}
+}
+
+func TestParseMetadataFromLegacyEnv(t *testing.T) {
+	m, env := parseMetadata(&dockerclient.ContainerConfig{
+		Env: []string{"_citadel_type=batch", "_citadel_labels=local,us-east-1", "FOO=bar"},
+	})
+
+	if m.Type != "batch" {
+		t.Fatalf("expected type batch received %s", m.Type)
+	}
+
+	if len(m.Labels) != 2 || m.Labels[1] != "us-east-1" {
+		t.Fatalf("unexpected labels %v", m.Labels)
+	}
+
+	if len(env) != 1 || env["FOO"] != "bar" {
+		t.Fatalf("expected citadel vars to be removed from the environment received %v", env)
+	}
+}
+
+func TestParseMetadataRestoresImage(t *testing.T) {
+	image := &Image{
+		Name:          "registry.local:5000/team/app:1.0",
+		Cpus:          0.5,
+		Memory:        256,
+		Args:          []string{"-debug"},
+		Links:         map[string]string{"redis": "db"},
+		BindPorts:     []*Port{{Proto: "tcp", Port: 8080, ContainerPort: 80}},
+		Publish:       true,
+		UserData:      map[string][]string{"keys": {"a", "b"}},
+		ContainerName: "app",
+	}
+
+	labels, err := containerLabels(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ := parseMetadata(&dockerclient.ContainerConfig{Labels: labels})
+	if m.Image == nil {
+		t.Fatal("expected image to be restored")
+	}
+
+	expected, err := image.SpecHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := m.Image.SpecHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash != expected || hash != m.SpecHash {
+		t.Fatalf("expected restored image to match the original spec")
+	}
+}
+
+func TestStoredSpecHidesPrivateData(t *testing.T) {
+	image := &Image{
+		Name:             "app",
+		Environment:      map[string]string{"PASSWORD": "secret"},
+		UserData:         map[string][]string{"token": {"secret"}},
+		UserDataDelivery: UserDataMetadata,
+	}
+
+	labels, err := containerLabels(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(labels[LabelImage], "secret") {
+		t.Fatalf("expected the stored spec not to contain user data or env received %s", labels[LabelImage])
+	}
+
+	m, _ := parseMetadata(&dockerclient.ContainerConfig{
+		Env:    []string{"PASSWORD=secret"},
+		Labels: labels,
+	})
+
+	if m.Image.Environment["PASSWORD"] != "secret" {
+		t.Fatalf("expected the environment to be restored from the container's config")
+	}
+}
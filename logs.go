@@ -0,0 +1,164 @@
+package citadel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// LogOptions control which logs are returned for a container
+type LogOptions struct {
+	// Follow keeps the stream open and returns new logs as they are written
+	Follow bool `json:"follow,omitempty"`
+
+	// Since only returns logs written after the time
+	Since time.Time `json:"since,omitempty"`
+
+	// Tail is the number of lines to return from the end of the logs, zero returns all
+	Tail int `json:"tail,omitempty"`
+
+	// Timestamps prefixes every line with the time it was written
+	Timestamps bool `json:"timestamps,omitempty"`
+}
+
+// LogMessage is a chunk of output written by a container
+type LogMessage struct {
+	// Stream is either stdout or stderr
+	Stream string `json:"stream,omitempty"`
+
+	Data []byte `json:"data,omitempty"`
+}
+
+// LogStream is the demultiplexed output of a container
+type LogStream struct {
+	body io.ReadCloser
+	r    *bufio.Reader
+	tty  bool
+}
+
+// Next returns the next message of the stream or io.EOF when the stream ends
+func (l *LogStream) Next() (*LogMessage, error) {
+	// containers with a tty have a single raw stream that docker does not multiplex
+	if l.tty {
+		buf := make([]byte, 32*1024)
+
+		n, err := l.r.Read(buf)
+		if n > 0 {
+			return &LogMessage{Stream: Stdout, Data: buf[:n]}, nil
+		}
+
+		return nil, err
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(l.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+
+		return nil, err
+	}
+
+	m := &LogMessage{
+		Stream: Stdout,
+		Data:   make([]byte, binary.BigEndian.Uint32(header[4:])),
+	}
+
+	if header[0] == 2 {
+		m.Stream = Stderr
+	}
+
+	if _, err := io.ReadFull(l.r, m.Data); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Copy writes the messages of the stream to stdout and stderr until the stream ends
+func (l *LogStream) Copy(stdout, stderr io.Writer) error {
+	for {
+		m, err := l.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		w := stdout
+		if m.Stream == Stderr {
+			w = stderr
+		}
+
+		if _, err := w.Write(m.Data); err != nil {
+			return err
+		}
+	}
+}
+
+func (l *LogStream) Close() error {
+	return l.body.Close()
+}
+
+func newLogStream(resp *http.Response, tty bool) *LogStream {
+	return &LogStream{
+		body: resp.Body,
+		r:    bufio.NewReader(resp.Body),
+		tty:  tty,
+	}
+}
+
+// Logs returns the stdout and stderr of the container
+func (e *Engine) Logs(c *Container, opts *LogOptions) (*LogStream, error) {
+	info, err := e.client.InspectContainer(c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = &LogOptions{}
+	}
+
+	query := url.Values{
+		"stdout":     {"1"},
+		"stderr":     {"1"},
+		"follow":     {boolParam(opts.Follow)},
+		"timestamps": {boolParam(opts.Timestamps)},
+		"tail":       {"all"},
+	}
+
+	if opts.Tail > 0 {
+		query.Set("tail", strconv.Itoa(opts.Tail))
+	}
+
+	if !opts.Since.IsZero() {
+		query.Set("since", strconv.FormatInt(opts.Since.Unix(), 10))
+	}
+
+	resp, err := e.request("GET", fmt.Sprintf("/containers/%s/logs", c.ID), query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLogStream(resp, info.Config.Tty), nil
+}
+
+func boolParam(b bool) string {
+	if b {
+		return "1"
+	}
+
+	return "0"
+}
@@ -0,0 +1,39 @@
+package citadel
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func frame(stream byte, data string) []byte {
+	header := []byte{stream, 0, 0, 0, 0, 0, 0, byte(len(data))}
+
+	return append(header, []byte(data)...)
+}
+
+func TestLogStreamDemultiplexes(t *testing.T) {
+	raw := bytes.NewBuffer(nil)
+	raw.Write(frame(1, "hello\n"))
+	raw.Write(frame(2, "oops\n"))
+	raw.Write(frame(1, "world\n"))
+
+	var (
+		stdout = bytes.NewBuffer(nil)
+		stderr = bytes.NewBuffer(nil)
+		l      = newLogStream(&http.Response{Body: ioutil.NopCloser(raw)}, false)
+	)
+
+	if err := l.Copy(stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := stdout.String(); s != "hello\nworld\n" {
+		t.Fatalf("unexpected stdout %q", s)
+	}
+
+	if s := stderr.String(); s != "oops\n" {
+		t.Fatalf("unexpected stderr %q", s)
+	}
+}
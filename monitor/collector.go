@@ -0,0 +1,242 @@
+package monitor
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+const (
+	// DefaultInterval is how often a collector looks for new containers when none is given
+	DefaultInterval = 10 * time.Second
+
+	// DefaultWindowSize is the number of samples averaged when no size is given
+	DefaultWindowSize = 30
+)
+
+// Collector streams the stats of every running container on its engines and keeps a
+// rolling average of their cpu and memory usage
+type Collector struct {
+	mux sync.Mutex
+
+	interval time.Duration
+	size     int
+	engines  map[string]*engineUsage
+	handlers []citadel.StatsHandler
+}
+
+type engineUsage struct {
+	engine     *citadel.Engine
+	containers map[string]*containerUsage
+	stop       chan struct{}
+}
+
+type containerUsage struct {
+	cpus   *window
+	memory *window
+	stop   chan struct{}
+}
+
+// New returns a collector that looks for new containers on its engines every interval
+// and averages the last size samples of each container, defaults are used for values
+// less than one
+func New(interval time.Duration, size int) *Collector {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	if size < 1 {
+		size = DefaultWindowSize
+	}
+
+	return &Collector{
+		interval: interval,
+		size:     size,
+		engines:  make(map[string]*engineUsage),
+	}
+}
+
+// AddHandler registers a handler that receives every sample collected
+func (c *Collector) AddHandler(h citadel.StatsHandler) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.handlers = append(c.handlers, h)
+}
+
+// AddEngine starts collecting the stats of the containers on the engine
+func (c *Collector) AddEngine(e *citadel.Engine) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if _, exists := c.engines[e.ID]; exists {
+		return
+	}
+
+	u := &engineUsage{
+		engine:     e,
+		containers: make(map[string]*containerUsage),
+		stop:       make(chan struct{}),
+	}
+
+	c.engines[e.ID] = u
+
+	go c.watch(u)
+}
+
+// RemoveEngine stops collecting stats for the engine
+func (c *Collector) RemoveEngine(e *citadel.Engine) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if u, exists := c.engines[e.ID]; exists {
+		c.stopEngine(u)
+		delete(c.engines, e.ID)
+	}
+}
+
+// Usage returns the sum of the averaged usage of the containers running on the engine
+func (c *Collector) Usage(e *citadel.Engine) (float64, float64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	u, exists := c.engines[e.ID]
+	if !exists {
+		return 0, 0
+	}
+
+	var cpus, memory float64
+	for _, cu := range u.containers {
+		cpus += cu.cpus.average()
+		memory += cu.memory.average()
+	}
+
+	return cpus, memory
+}
+
+// Close stops all the stats streams of the collector
+func (c *Collector) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for id, u := range c.engines {
+		c.stopEngine(u)
+		delete(c.engines, id)
+	}
+
+	return nil
+}
+
+func (c *Collector) stopEngine(u *engineUsage) {
+	close(u.stop)
+
+	for id, cu := range u.containers {
+		close(cu.stop)
+		delete(u.containers, id)
+	}
+}
+
+// watch syncs the streams of the engine with its running containers until the engine
+// is removed from the collector
+func (c *Collector) watch(u *engineUsage) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.sync(u); err != nil {
+			log.Printf("monitor: unable to list containers on %s: %s\n", u.engine, err)
+		}
+
+		select {
+		case <-u.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Collector) sync(u *engineUsage) error {
+	containers, err := u.engine.ListContainers(false)
+	if err != nil {
+		return err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	// the engine may have been removed while we were listing its containers
+	select {
+	case <-u.stop:
+		return nil
+	default:
+	}
+
+	running := make(map[string]bool)
+
+	for _, container := range containers {
+		running[container.ID] = true
+
+		if _, exists := u.containers[container.ID]; exists {
+			continue
+		}
+
+		cu := &containerUsage{
+			cpus:   newWindow(c.size),
+			memory: newWindow(c.size),
+			stop:   make(chan struct{}),
+		}
+
+		u.containers[container.ID] = cu
+
+		go c.stream(u, container, cu)
+	}
+
+	for id, cu := range u.containers {
+		if !running[id] {
+			close(cu.stop)
+			delete(u.containers, id)
+		}
+	}
+
+	return nil
+}
+
+func (c *Collector) stream(u *engineUsage, container *citadel.Container, cu *containerUsage) {
+	h := &containerHandler{collector: c, usage: cu}
+
+	if err := u.engine.Stats(container, h, cu.stop); err != nil {
+		log.Printf("monitor: stats stream for %s ended: %s\n", container, err)
+	}
+
+	// remove the container so that the next sync restarts the stream if the container
+	// is still running
+	c.mux.Lock()
+	if u.containers[container.ID] == cu {
+		delete(u.containers, container.ID)
+		close(cu.stop)
+	}
+	c.mux.Unlock()
+}
+
+type containerHandler struct {
+	collector *Collector
+	usage     *containerUsage
+}
+
+func (h *containerHandler) HandleStats(s *citadel.ContainerStats) error {
+	h.collector.mux.Lock()
+	h.usage.cpus.add(s.Cpus)
+	h.usage.memory.add(s.Memory)
+	handlers := h.collector.handlers
+	h.collector.mux.Unlock()
+
+	for _, handler := range handlers {
+		if err := handler.HandleStats(s); err != nil {
+			log.Printf("monitor: stats handler error: %s\n", err)
+		}
+	}
+
+	return nil
+}
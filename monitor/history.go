@@ -0,0 +1,266 @@
+package monitor
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/citadel/citadel"
+)
+
+// DefaultHistorySize is the number of samples kept for each image when no size is given
+const DefaultHistorySize = 1000
+
+const (
+	OverProvisioned  = "over-provisioned"
+	UnderProvisioned = "under-provisioned"
+	Provisioned      = "provisioned"
+)
+
+// Usage is the distribution of a resource used by the containers of an image
+type Usage struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	Max float64 `json:"max"`
+}
+
+// Recommendation compares what an image requested with what its containers used
+type Recommendation struct {
+	// Image is the name of the image
+	Image string `json:"image,omitempty"`
+
+	// Samples is the number of samples the recommendation is based on
+	Samples int `json:"samples,omitempty"`
+
+	// Status is one of over-provisioned, under-provisioned or provisioned
+	Status string `json:"status,omitempty"`
+
+	RequestedCpus   float64 `json:"requested_cpus"`
+	RequestedMemory float64 `json:"requested_memory"`
+
+	Cpus   Usage `json:"cpus"`
+	Memory Usage `json:"memory"`
+
+	SuggestedCpus   float64 `json:"suggested_cpus"`
+	SuggestedMemory float64 `json:"suggested_memory"`
+}
+
+// History aggregates the usage samples of containers by their image reference so that the
+// requested resources of an image can be compared with what it really uses
+type History struct {
+	mux sync.Mutex
+
+	// Headroom is the fraction added on top of the p95 usage for suggested values
+	Headroom float64
+
+	// Tolerance is the fraction that the requested resources can differ from the
+	// suggested values before an image is reported
+	Tolerance float64
+
+	// MinSamples is the number of samples required before an image is reported, it is
+	// capped at the size of the history
+	MinSamples int
+
+	size   int
+	images map[string]*imageHistory
+}
+
+type imageHistory struct {
+	requestedCpus   float64
+	requestedMemory float64
+	cpus            []float64
+	memory          []float64
+	next            int
+}
+
+// NewHistory returns a history that keeps the last size samples for each image,
+// DefaultHistorySize is used when size is less than one
+func NewHistory(size int) *History {
+	if size < 1 {
+		size = DefaultHistorySize
+	}
+
+	minSamples := 10
+	if minSamples > size {
+		minSamples = size
+	}
+
+	return &History{
+		Headroom:   0.2,
+		Tolerance:  0.25,
+		MinSamples: minSamples,
+		size:       size,
+		images:     make(map[string]*imageHistory),
+	}
+}
+
+// reportable returns true if the image has enough samples to be reported
+func (h *History) reportable(ih *imageHistory) bool {
+	min := h.MinSamples
+	if min > h.size {
+		min = h.size
+	}
+
+	return len(ih.cpus) > 0 && len(ih.cpus) >= min
+}
+
+// HandleStats records the sample against the image of its container
+func (h *History) HandleStats(s *citadel.ContainerStats) error {
+	if s.Container == nil || s.Container.Image == nil {
+		return nil
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	var (
+		image = s.Container.Image
+		name  = citadel.ParseReference(image.Name).String()
+	)
+
+	ih, exists := h.images[name]
+	if !exists {
+		ih = &imageHistory{}
+		h.images[name] = ih
+	}
+
+	ih.requestedCpus = image.Cpus
+	ih.requestedMemory = image.Memory
+
+	if len(ih.cpus) < h.size {
+		ih.cpus = append(ih.cpus, s.Cpus)
+		ih.memory = append(ih.memory, s.Memory)
+
+		return nil
+	}
+
+	ih.cpus[ih.next] = s.Cpus
+	ih.memory[ih.next] = s.Memory
+	ih.next = (ih.next + 1) % h.size
+
+	return nil
+}
+
+// Recommendation returns the recommendation for the image or nil if there are not
+// enough samples for it
+func (h *History) Recommendation(name string) *Recommendation {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	name = citadel.ParseReference(name).String()
+
+	ih, exists := h.images[name]
+	if !exists || !h.reportable(ih) {
+		return nil
+	}
+
+	return h.recommend(name, ih)
+}
+
+// Recommendations returns the images that are over or under provisioned
+func (h *History) Recommendations() []*Recommendation {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	out := []*Recommendation{}
+
+	for name, ih := range h.images {
+		if !h.reportable(ih) {
+			continue
+		}
+
+		if r := h.recommend(name, ih); r.Status != Provisioned {
+			out = append(out, r)
+		}
+	}
+
+	sort.Sort(recommendations(out))
+
+	return out
+}
+
+// ApplyDefaults sets the cpus and memory of an image that was submitted without them
+// to the values suggested by its history
+func (h *History) ApplyDefaults(image *citadel.Image) {
+	if image.Cpus != 0 && image.Memory != 0 {
+		return
+	}
+
+	r := h.Recommendation(image.Name)
+	if r == nil {
+		return
+	}
+
+	if image.Cpus == 0 {
+		image.Cpus = r.SuggestedCpus
+	}
+
+	if image.Memory == 0 {
+		image.Memory = r.SuggestedMemory
+	}
+}
+
+func (h *History) recommend(name string, ih *imageHistory) *Recommendation {
+	r := &Recommendation{
+		Image:           name,
+		Samples:         len(ih.cpus),
+		RequestedCpus:   ih.requestedCpus,
+		RequestedMemory: ih.requestedMemory,
+		Cpus:            distribution(ih.cpus),
+		Memory:          distribution(ih.memory),
+	}
+
+	r.SuggestedCpus = round(r.Cpus.P95*(1+h.Headroom), 100)
+	r.SuggestedMemory = math.Ceil(r.Memory.P95 * (1 + h.Headroom))
+
+	switch {
+	case r.Cpus.P95 > r.RequestedCpus || r.Memory.P95 > r.RequestedMemory:
+		r.Status = UnderProvisioned
+	case r.SuggestedCpus < r.RequestedCpus*(1-h.Tolerance) || r.SuggestedMemory < r.RequestedMemory*(1-h.Tolerance):
+		r.Status = OverProvisioned
+	default:
+		r.Status = Provisioned
+	}
+
+	return r
+}
+
+func distribution(values []float64) Usage {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	return Usage{
+		P50: percentile(sorted, 50),
+		P95: percentile(sorted, 95),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+// percentile returns the nearest rank percentile of the sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100.0 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+func round(v, precision float64) float64 {
+	return math.Ceil(v*precision) / precision
+}
+
+type recommendations []*Recommendation
+
+func (r recommendations) Len() int {
+	return len(r)
+}
+
+func (r recommendations) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func (r recommendations) Less(i, j int) bool {
+	return r[i].Image < r[j].Image
+}
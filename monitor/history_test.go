@@ -0,0 +1,92 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/citadel/citadel"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	if p := percentile(values, 50); p != 5 {
+		t.Fatalf("expected p50 to be 5 received %f", p)
+	}
+
+	if p := percentile(values, 95); p != 10 {
+		t.Fatalf("expected p95 to be 10 received %f", p)
+	}
+}
+
+func TestHistoryRecommendations(t *testing.T) {
+	var (
+		h    = NewHistory(100)
+		over = &citadel.Container{
+			Image: &citadel.Image{Name: "crosbymichael/redis", Cpus: 2, Memory: 2048},
+		}
+		under = &citadel.Container{
+			Image: &citadel.Image{Name: "ehazlett/go-demo", Cpus: 0.1, Memory: 64},
+		}
+	)
+
+	for i := 0; i < 20; i++ {
+		h.HandleStats(&citadel.ContainerStats{Container: over, Cpus: 0.2, Memory: 256})
+		h.HandleStats(&citadel.ContainerStats{Container: under, Cpus: 0.5, Memory: 128})
+	}
+
+	r := h.Recommendations()
+	if len(r) != 2 {
+		t.Fatalf("expected 2 recommendations received %d", len(r))
+	}
+
+	if r[0].Image != "crosbymichael/redis:latest" || r[0].Status != OverProvisioned {
+		t.Fatalf("expected redis to be over-provisioned received %s %s", r[0].Image, r[0].Status)
+	}
+
+	if r[1].Status != UnderProvisioned {
+		t.Fatalf("expected go-demo to be under-provisioned received %s", r[1].Status)
+	}
+
+	image := &citadel.Image{Name: "crosbymichael/redis"}
+	h.ApplyDefaults(image)
+
+	if image.Cpus != 0.24 || image.Memory != 308 {
+		t.Fatalf("expected defaults of 0.24 cpus and 308 memory received %f %f", image.Cpus, image.Memory)
+	}
+}
+
+func TestSmallHistoryRecommends(t *testing.T) {
+	var (
+		h = NewHistory(5)
+		c = &citadel.Container{
+			Image: &citadel.Image{Name: "crosbymichael/redis", Cpus: 2, Memory: 2048},
+		}
+	)
+
+	for i := 0; i < 10; i++ {
+		h.HandleStats(&citadel.ContainerStats{Container: c, Cpus: 0.2, Memory: 256})
+	}
+
+	r := h.Recommendation("crosbymichael/redis")
+	if r == nil {
+		t.Fatal("expected a recommendation once the history is full")
+	}
+
+	if r.Samples != 5 {
+		t.Fatalf("expected 5 samples received %d", r.Samples)
+	}
+}
+
+func TestHistoryWithoutSize(t *testing.T) {
+	var (
+		h = NewHistory(0)
+		c = &citadel.Container{Image: &citadel.Image{Name: "crosbymichael/redis"}}
+	)
+
+	h.HandleStats(&citadel.ContainerStats{Container: c, Cpus: 0.2, Memory: 256})
+	h.HandleStats(&citadel.ContainerStats{Container: c, Cpus: 0.2, Memory: 256})
+
+	if h.size != DefaultHistorySize {
+		t.Fatalf("expected size %d received %d", DefaultHistorySize, h.size)
+	}
+}
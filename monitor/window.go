@@ -0,0 +1,46 @@
+package monitor
+
+// window keeps the last n values that were added and returns their average
+type window struct {
+	values []float64
+	next   int
+	full   bool
+}
+
+// newWindow returns a window of size values, a size less than one keeps the last value
+func newWindow(size int) *window {
+	if size < 1 {
+		size = 1
+	}
+
+	return &window{
+		values: make([]float64, size),
+	}
+}
+
+func (w *window) add(v float64) {
+	w.values[w.next] = v
+	w.next = (w.next + 1) % len(w.values)
+
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+func (w *window) average() float64 {
+	count := w.next
+	if w.full {
+		count = len(w.values)
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	var total float64
+	for _, v := range w.values[:count] {
+		total += v
+	}
+
+	return total / float64(count)
+}
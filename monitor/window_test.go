@@ -0,0 +1,33 @@
+package monitor
+
+import "testing"
+
+func TestWindowAverage(t *testing.T) {
+	w := newWindow(2)
+
+	for _, v := range []float64{1, 2, 4} {
+		w.add(v)
+	}
+
+	if a := w.average(); a != 3 {
+		t.Fatalf("expected the average of the last 2 values to be 3 received %f", a)
+	}
+}
+
+func TestWindowWithoutSize(t *testing.T) {
+	w := newWindow(0)
+	w.add(1)
+	w.add(2)
+
+	if a := w.average(); a != 2 {
+		t.Fatalf("expected the last value 2 received %f", a)
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	c := New(0, 0)
+
+	if c.interval != DefaultInterval || c.size != DefaultWindowSize {
+		t.Fatalf("expected interval %s and size %d received %s and %d", DefaultInterval, DefaultWindowSize, c.interval, c.size)
+	}
+}
@@ -5,3 +5,10 @@ type Port struct {
 	Port          int    `json:"port,omitempty"`
 	ContainerPort int    `json:"container_port,omitempty"`
 }
+
+// PortAllocator assigns host ports to the bind ports of an image that do not request a
+// specific host port
+type PortAllocator interface {
+	// AllocatePorts returns the image's bind ports with all host ports assigned on the engine
+	AllocatePorts(*Image, *Engine) ([]*Port, error)
+}
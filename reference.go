@@ -0,0 +1,107 @@
+package citadel
+
+import "strings"
+
+// Reference is a parsed docker image reference such as
+// registry.local:5000/team/app:1.0 or redis@sha256:...
+type Reference struct {
+	// Registry is the registry host and port, empty for the docker hub
+	Registry string `json:"registry,omitempty"`
+
+	// Repository is the repository path within the registry
+	Repository string `json:"repository,omitempty"`
+
+	// Tag is the image tag, latest when the reference has no tag or digest
+	Tag string `json:"tag,omitempty"`
+
+	// Digest is the content digest of the image, sha256:...
+	Digest string `json:"digest,omitempty"`
+}
+
+// ParseReference parses an image name into its registry, repository, tag and digest
+func ParseReference(name string) *Reference {
+	r := &Reference{}
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+	}
+
+	// a tag can only follow the last path component, colons before it are registry ports
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+	}
+
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		r.Registry, name = parts[0], parts[1]
+	}
+
+	switch r.Registry {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		r.Registry = ""
+	}
+
+	// official images on the docker hub live in the library namespace
+	if r.Registry == "" {
+		name = strings.TrimPrefix(name, "library/")
+	}
+
+	r.Repository = name
+
+	return r
+}
+
+// Name returns the registry and repository of the reference without a tag or digest
+func (r *Reference) Name() string {
+	if r.Registry == "" {
+		return r.Repository
+	}
+
+	return r.Registry + "/" + r.Repository
+}
+
+// RegistryHost returns the registry host of the reference, DefaultRegistry for the hub
+func (r *Reference) RegistryHost() string {
+	if r.Registry == "" {
+		return DefaultRegistry
+	}
+
+	return r.Registry
+}
+
+// String returns the normalized reference
+func (r *Reference) String() string {
+	s := r.Name()
+
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+
+	return s
+}
+
+// Equal returns true if both references point to the same image.  Digests are only
+// compared when both references have one.
+func (r *Reference) Equal(o *Reference) bool {
+	if r.Name() != o.Name() {
+		return false
+	}
+
+	if r.Digest != "" && o.Digest != "" {
+		return r.Digest == o.Digest
+	}
+
+	if r.Digest != "" || o.Digest != "" {
+		return false
+	}
+
+	return r.Tag == o.Tag
+}
@@ -0,0 +1,47 @@
+package citadel
+
+import "testing"
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected Reference
+		str      string
+	}{
+		{"redis", Reference{Repository: "redis", Tag: "latest"}, "redis:latest"},
+		{"library/redis:2.8", Reference{Repository: "redis", Tag: "2.8"}, "redis:2.8"},
+		{"crosbymichael/redis", Reference{Repository: "crosbymichael/redis", Tag: "latest"}, "crosbymichael/redis:latest"},
+		{"docker.io/crosbymichael/redis:1", Reference{Repository: "crosbymichael/redis", Tag: "1"}, "crosbymichael/redis:1"},
+		{"registry.local:5000/team/app", Reference{Registry: "registry.local:5000", Repository: "team/app", Tag: "latest"}, "registry.local:5000/team/app:latest"},
+		{"registry.local:5000/team/app:1.0", Reference{Registry: "registry.local:5000", Repository: "team/app", Tag: "1.0"}, "registry.local:5000/team/app:1.0"},
+		{"localhost/app", Reference{Registry: "localhost", Repository: "app", Tag: "latest"}, "localhost/app:latest"},
+		{"redis@sha256:abc", Reference{Repository: "redis", Digest: "sha256:abc"}, "redis@sha256:abc"},
+		{"registry.local:5000/app:1.0@sha256:abc", Reference{Registry: "registry.local:5000", Repository: "app", Tag: "1.0", Digest: "sha256:abc"}, "registry.local:5000/app:1.0@sha256:abc"},
+	}
+
+	for _, test := range tests {
+		r := ParseReference(test.name)
+
+		if *r != test.expected {
+			t.Fatalf("%s: expected %+v received %+v", test.name, test.expected, *r)
+		}
+
+		if s := r.String(); s != test.str {
+			t.Fatalf("%s: expected string %s received %s", test.name, test.str, s)
+		}
+	}
+}
+
+func TestReferenceEqual(t *testing.T) {
+	if !ParseReference("redis").Equal(ParseReference("library/redis:latest")) {
+		t.Fatal("expected redis to equal library/redis:latest")
+	}
+
+	if ParseReference("redis:2.8").Equal(ParseReference("redis")) {
+		t.Fatal("expected redis:2.8 not to equal redis:latest")
+	}
+
+	if ParseReference("redis@sha256:abc").Equal(ParseReference("redis")) {
+		t.Fatal("expected a digest reference not to equal a tag reference")
+	}
+}
@@ -0,0 +1,183 @@
+package citadel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultRegistry is the registry used for images that do not include a registry host
+const DefaultRegistry = "index.docker.io"
+
+// RegistryAuth are the credentials used to pull images from a registry
+type RegistryAuth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Email    string `json:"email,omitempty"`
+
+	// ServerAddress is the registry host that the credentials are for
+	ServerAddress string `json:"server_address,omitempty"`
+}
+
+// PullProgress is a progress message sent by docker while it pulls an image
+type PullProgress struct {
+	// Image is the image being pulled
+	Image string `json:"image,omitempty"`
+
+	// ID is the layer that the message is about
+	ID string `json:"id,omitempty"`
+
+	Status   string `json:"status,omitempty"`
+	Progress string `json:"progress,omitempty"`
+	Current  int64  `json:"current,omitempty"`
+	Total    int64  `json:"total,omitempty"`
+}
+
+// PullProgressFunc is called for every progress message of a pull
+type PullProgressFunc func(*PullProgress)
+
+// host returns the registry host of the credentials without a scheme or path
+func (a *RegistryAuth) host() string {
+	h := a.ServerAddress
+	if i := strings.Index(h, "://"); i >= 0 {
+		h = h[i+3:]
+	}
+
+	if i := strings.Index(h, "/"); i >= 0 {
+		h = h[:i]
+	}
+
+	if h == "" || h == "docker.io" || h == "registry-1.docker.io" {
+		return DefaultRegistry
+	}
+
+	return h
+}
+
+// encode returns the credentials in the format of docker's X-Registry-Auth header
+func (a *RegistryAuth) encode() (string, error) {
+	data, err := json.Marshal(map[string]string{
+		"username":      a.Username,
+		"password":      a.Password,
+		"email":         a.Email,
+		"serveraddress": a.ServerAddress,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// RegistryAuthFor returns the credentials for the registry of the image or nil
+func RegistryAuthFor(auths []*RegistryAuth, image string) *RegistryAuth {
+	registry := ParseReference(image).RegistryHost()
+
+	for _, a := range auths {
+		if a.host() == registry {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// RegistryAuthFor returns the engine's credentials for the registry of the image or nil
+func (e *Engine) RegistryAuthFor(image string) *RegistryAuth {
+	return RegistryAuthFor(e.RegistryAuth, image)
+}
+
+// Pull pulls the image on the engine using the credentials.  Progress messages are sent to
+// the progress func, if one is provided, and published as pull events to the engine's
+// event handler.
+func (e *Engine) Pull(image string, auth *RegistryAuth, progress PullProgressFunc) error {
+	var (
+		ref   = ParseReference(image)
+		query = url.Values{"fromImage": {ref.Name()}}
+	)
+
+	// docker resolves digests itself when they are part of the image name, the tag is
+	// dropped as the digest already pins the image
+	if ref.Digest != "" {
+		query.Set("fromImage", ref.Name()+"@"+ref.Digest)
+	} else {
+		query.Set("tag", ref.Tag)
+	}
+
+	req, err := e.newRequest("POST", "/images/create", query, nil)
+	if err != nil {
+		return err
+	}
+
+	if auth != nil {
+		header, err := auth.encode()
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("X-Registry-Auth", header)
+	}
+
+	resp, err := e.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return e.readPullProgress(image, resp, progress)
+}
+
+func (e *Engine) readPullProgress(image string, resp *http.Response, progress PullProgressFunc) error {
+	dec := json.NewDecoder(resp.Body)
+
+	for {
+		var m struct {
+			ID             string `json:"id"`
+			Status         string `json:"status"`
+			Progress       string `json:"progress"`
+			ProgressDetail struct {
+				Current int64 `json:"current"`
+				Total   int64 `json:"total"`
+			} `json:"progressDetail"`
+			Error string `json:"error"`
+		}
+
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		// docker reports pull failures in the stream after sending a 200 status
+		if m.Error != "" {
+			return fmt.Errorf("pull %s: %s", image, m.Error)
+		}
+
+		p := &PullProgress{
+			Image:    image,
+			ID:       m.ID,
+			Status:   m.Status,
+			Progress: m.Progress,
+			Current:  m.ProgressDetail.Current,
+			Total:    m.ProgressDetail.Total,
+		}
+
+		if progress != nil {
+			progress(p)
+		}
+
+		e.deliver(&Event{
+			Type:   EventPull,
+			Engine: e,
+			Time:   time.Now(),
+			Pull:   p,
+		})
+	}
+}
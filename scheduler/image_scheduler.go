@@ -1,11 +1,6 @@
 package scheduler
 
-import (
-	"fmt"
-	"strings"
-
-	"github.com/citadel/citadel"
-)
+import "github.com/citadel/citadel"
 
 // ImageScheduler only returns engines that already have the image pulled
 // locally on disk for docker to use
@@ -13,28 +8,25 @@ type ImageScheduler struct {
 }
 
 func (i *ImageScheduler) Schedule(c *citadel.Image, e *citadel.Engine) (bool, error) {
-	fullImage := c.Name
-
-	if !strings.Contains(fullImage, ":") {
-		fullImage = fmt.Sprintf("%s:latest", fullImage)
-	}
-
-	images, err := e.ListImages()
+	images, err := e.EngineImages()
 	if err != nil {
 		return false, err
 	}
 
-	if i.containsImage(fullImage, images) {
+	if i.containsImage(citadel.ParseReference(c.Name), images) {
 		return true, nil
 	}
 
 	return false, nil
 }
 
-func (i *ImageScheduler) containsImage(requested string, images []string) bool {
-	for _, tag := range images {
-		if requested == tag {
-			return true
+// containsImage returns true if one of the images has a tag or digest matching the reference
+func (i *ImageScheduler) containsImage(requested *citadel.Reference, images []*citadel.EngineImage) bool {
+	for _, image := range images {
+		for _, name := range append(image.Tags, image.Digests...) {
+			if requested.Equal(citadel.ParseReference(name)) {
+				return true
+			}
 		}
 	}
 
@@ -0,0 +1,31 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/citadel/citadel"
+)
+
+func TestImageSchedulerMatchesDigests(t *testing.T) {
+	var (
+		s      = &ImageScheduler{}
+		digest = "sha256:8c3bd3d2b9a1d0e61a6b1d2fbb4c6d3e8c0b2a7d39f5e1c4a2b6d8e0f1a3c5b7"
+		images = []*citadel.EngineImage{
+			{
+				ID:      "1",
+				Tags:    []string{"crosbymichael/redis:latest"},
+				Digests: []string{"crosbymichael/redis@" + digest},
+			},
+		}
+	)
+
+	for _, name := range []string{"crosbymichael/redis", "crosbymichael/redis@" + digest, "crosbymichael/redis:latest@" + digest} {
+		if !s.containsImage(citadel.ParseReference(name), images) {
+			t.Fatalf("expected %s to match the engine's image", name)
+		}
+	}
+
+	if s.containsImage(citadel.ParseReference("crosbymichael/redis@sha256:0000"), images) {
+		t.Fatal("expected a different digest not to match")
+	}
+}
@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/citadel/citadel"
+)
+
+// LoadResourceManager places containers using both the static reservations and the live
+// usage of the engines so that hot engines are avoided even if their reservations look free
+type LoadResourceManager struct {
+	// MaxLoad is the percentage of live cpu or memory usage above which an engine
+	// will not receive new containers
+	MaxLoad float64
+}
+
+func NewLoadResourceManager(maxLoad float64) *LoadResourceManager {
+	return &LoadResourceManager{
+		MaxLoad: maxLoad,
+	}
+}
+
+// PlaceContainer scores the engines on the greater of their reserved and used resources
+func (r *LoadResourceManager) PlaceContainer(c *citadel.Container, engines []*citadel.EngineSnapshot) (*citadel.EngineSnapshot, error) {
+	scores := []*score{}
+
+	for _, e := range engines {
+		if e.Memory < c.Image.Memory || e.Cpus < c.Image.Cpus {
+			continue
+		}
+
+		var (
+			cpuLoad    = ((e.CurrentCpu + c.Image.Cpus) / e.Cpus) * 100.0
+			memoryLoad = ((e.CurrentMemory + c.Image.Memory) / e.Memory) * 100.0
+		)
+
+		if cpuLoad > r.MaxLoad || memoryLoad > r.MaxLoad {
+			continue
+		}
+
+		var (
+			cpuScore    = ((math.Max(e.ReservedCpus, e.CurrentCpu) + c.Image.Cpus) / e.Cpus) * 100.0
+			memoryScore = ((math.Max(e.ReservedMemory, e.CurrentMemory) + c.Image.Memory) / e.Memory) * 100.0
+		)
+
+		total, fits := totalScore(e, c, cpuScore, memoryScore)
+		if fits && total <= 100.0 {
+			scores = append(scores, &score{r: e, score: total})
+		}
+	}
+
+	if len(scores) == 0 {
+		return nil, fmt.Errorf("no resources avaliable to schedule container")
+	}
+
+	sortScores(scores)
+
+	return scores[0].r, nil
+}
@@ -0,0 +1,29 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/citadel/citadel"
+)
+
+func TestLoadResourceManagerAvoidsHotEngines(t *testing.T) {
+	var (
+		r = NewLoadResourceManager(80)
+		c = &citadel.Container{
+			Image: &citadel.Image{Cpus: 1, Memory: 512},
+		}
+		engines = []*citadel.EngineSnapshot{
+			{ID: "hot", Cpus: 4, Memory: 4096, ReservedCpus: 1, ReservedMemory: 1024, CurrentCpu: 3.5, CurrentMemory: 1024},
+			{ID: "cool", Cpus: 4, Memory: 4096, ReservedCpus: 1, ReservedMemory: 1024, CurrentCpu: 0.5, CurrentMemory: 512},
+		}
+	)
+
+	e, err := r.PlaceContainer(c, engines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.ID != "cool" {
+		t.Fatalf("expected engine cool received %s", e.ID)
+	}
+}
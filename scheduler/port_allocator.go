@@ -0,0 +1,94 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/citadel/citadel"
+)
+
+// PortAllocator assigns free host ports from a range to the bind ports of an image that
+// do not specify a host port
+type PortAllocator struct {
+	start int
+	end   int
+}
+
+// NewPortAllocator returns an allocator for the inclusive range of ports
+func NewPortAllocator(start, end int) *PortAllocator {
+	return &PortAllocator{
+		start: start,
+		end:   end,
+	}
+}
+
+func (p *PortAllocator) AllocatePorts(i *citadel.Image, e *citadel.Engine) ([]*citadel.Port, error) {
+	containers, err := e.ListContainers(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.allocate(i.BindPorts, usedPorts(containers))
+}
+
+// allocate assigns ports that are not in used to the bind ports without a host port,
+// used is left unchanged
+func (p *PortAllocator) allocate(bindPorts []*citadel.Port, inUse map[string]bool) ([]*citadel.Port, error) {
+	var (
+		out  = []*citadel.Port{}
+		used = make(map[string]bool, len(inUse))
+	)
+
+	for k, v := range inUse {
+		used[k] = v
+	}
+
+	// fixed ports requested by the image are not available to its dynamic ports
+	for _, b := range bindPorts {
+		if b.Port != 0 {
+			used[portKey(b.Port, b.Proto)] = true
+		}
+	}
+
+	next := p.start
+
+	for _, b := range bindPorts {
+		port := *b
+
+		if port.Port == 0 {
+			for ; next <= p.end && used[portKey(next, port.Proto)]; next++ {
+			}
+
+			if next > p.end {
+				return nil, fmt.Errorf("no free ports in range %d-%d", p.start, p.end)
+			}
+
+			port.Port = next
+			used[portKey(next, port.Proto)] = true
+		}
+
+		out = append(out, &port)
+	}
+
+	return out, nil
+}
+
+func usedPorts(containers []*citadel.Container) map[string]bool {
+	used := make(map[string]bool)
+
+	for _, c := range containers {
+		for _, p := range c.Ports {
+			used[portKey(p.Port, p.Proto)] = true
+		}
+	}
+
+	return used
+}
+
+func portKey(port int, proto string) string {
+	if proto == "" {
+		proto = "tcp"
+	}
+
+	return fmt.Sprintf("%d/%s", port, strings.ToLower(proto))
+}
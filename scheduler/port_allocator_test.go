@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/citadel/citadel"
+)
+
+func TestPortAllocatorSkipsUsedPorts(t *testing.T) {
+	var (
+		p         = NewPortAllocator(8000, 8003)
+		bindPorts = []*citadel.Port{
+			{Proto: "tcp", ContainerPort: 80},
+			{Proto: "tcp", Port: 8001, ContainerPort: 443},
+			{Proto: "tcp", ContainerPort: 8080},
+		}
+		used = map[string]bool{
+			"8000/tcp": true,
+		}
+	)
+
+	ports, err := p.allocate(bindPorts, used)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []int{8002, 8001, 8003}
+	for i, port := range ports {
+		if port.Port != expected[i] {
+			t.Fatalf("expected port %d received %d", expected[i], port.Port)
+		}
+	}
+
+	if bindPorts[0].Port != 0 {
+		t.Fatalf("expected image bind ports to be left unchanged")
+	}
+
+	if len(used) != 1 {
+		t.Fatalf("expected used ports to be left unchanged")
+	}
+
+	for _, port := range ports {
+		used[portKey(port.Port, port.Proto)] = true
+	}
+
+	if _, err := p.allocate(bindPorts, used); err == nil {
+		t.Fatalf("expected error when the range is exhausted")
+	}
+}
@@ -0,0 +1,34 @@
+package scheduler
+
+import "github.com/citadel/citadel"
+
+// PortScheduler only returns engines that do not already have the image's bind ports
+// bound by another container
+type PortScheduler struct {
+}
+
+func (p *PortScheduler) Schedule(c *citadel.Image, e *citadel.Engine) (bool, error) {
+	if len(c.BindPorts) == 0 {
+		return true, nil
+	}
+
+	containers, err := e.ListContainers(false)
+	if err != nil {
+		return false, err
+	}
+
+	used := usedPorts(containers)
+
+	for _, b := range c.BindPorts {
+		// a zero host port is assigned dynamically by the port allocator
+		if b.Port == 0 {
+			continue
+		}
+
+		if used[portKey(b.Port, b.Proto)] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -27,10 +27,10 @@ func (r *ResourceManager) PlaceContainer(c *citadel.Container, engines []*citade
 		var (
 			cpuScore    = ((e.ReservedCpus + c.Image.Cpus) / e.Cpus) * 100.0
 			memoryScore = ((e.ReservedMemory + c.Image.Memory) / e.Memory) * 100.0
-			total       = ((cpuScore + memoryScore) / 200.0) * 100.0
 		)
 
-		if total <= 100.0 {
+		total, fits := totalScore(e, c, cpuScore, memoryScore)
+		if fits && total <= 100.0 {
 			scores = append(scores, &score{r: e, score: total})
 		}
 	}
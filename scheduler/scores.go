@@ -13,6 +13,22 @@ type score struct {
 
 type scores []*score
 
+// totalScore averages the cpu and memory scores with the disk score of engines that
+// advertise their disk capacity.  It returns false if the container does not fit on the
+// engine's disk.
+func totalScore(e *citadel.EngineSnapshot, c *citadel.Container, cpuScore, memoryScore float64) (float64, bool) {
+	if e.Disk <= 0 {
+		return ((cpuScore + memoryScore) / 200.0) * 100.0, true
+	}
+
+	diskScore := ((e.ReservedDisk + c.Image.Disk) / e.Disk) * 100.0
+	if diskScore > 100.0 {
+		return 0, false
+	}
+
+	return ((cpuScore + memoryScore + diskScore) / 300.0) * 100.0, true
+}
+
 func sortScores(s []*score) {
 	sort.Sort(scores(s))
 }
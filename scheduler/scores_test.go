@@ -1,6 +1,10 @@
 package scheduler
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/citadel/citadel"
+)
 
 func TestSortScores(t *testing.T) {
 	s := []*score{
@@ -17,3 +21,19 @@ func TestSortScores(t *testing.T) {
 		t.Fatalf("expected first score to be 9.0 received %f", first.score)
 	}
 }
+
+func TestTotalScoreDisk(t *testing.T) {
+	c := &citadel.Container{Image: &citadel.Image{Disk: 20}}
+
+	if total, fits := totalScore(&citadel.EngineSnapshot{}, c, 50, 50); !fits || total != 50 {
+		t.Fatalf("expected a score of 50 without disk received %f", total)
+	}
+
+	if total, fits := totalScore(&citadel.EngineSnapshot{Disk: 100, ReservedDisk: 30}, c, 50, 50); !fits || total != 50 {
+		t.Fatalf("expected a score of 50 with disk received %f", total)
+	}
+
+	if _, fits := totalScore(&citadel.EngineSnapshot{Disk: 100, ReservedDisk: 90}, c, 50, 50); fits {
+		t.Fatal("expected the container not to fit on the engine's disk")
+	}
+}
@@ -1,11 +1,6 @@
 package scheduler
 
-import (
-	"fmt"
-	"strings"
-
-	"github.com/citadel/citadel"
-)
+import "github.com/citadel/citadel"
 
 // UniqueScheduler only returns engines that do not have the image running
 type UniqueScheduler struct {
@@ -25,14 +20,10 @@ func (u *UniqueScheduler) Schedule(c *citadel.Image, e *citadel.Engine) (bool, e
 }
 
 func (u *UniqueScheduler) hasImage(i *citadel.Image, containers []*citadel.Container) bool {
-	fullImage := i.Name
-
-	if !strings.Contains(fullImage, ":") {
-		fullImage = fmt.Sprintf("%s:latest", fullImage)
-	}
+	requested := citadel.ParseReference(i.Name)
 
 	for _, c := range containers {
-		if c.Image.Name == fullImage {
+		if requested.Equal(citadel.ParseReference(c.Image.Name)) {
 			return true
 		}
 	}
@@ -0,0 +1,129 @@
+package citadel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ContainerStats is a single sample of the resources used by a container
+type ContainerStats struct {
+	// Container is the container that the sample was taken from
+	Container *Container `json:"container,omitempty"`
+
+	// Time is when docker took the sample
+	Time time.Time `json:"time,omitempty"`
+
+	// Cpus is the number of cpus used by the container since the previous sample
+	Cpus float64 `json:"cpus,omitempty"`
+
+	// Memory is the amount of memory in MB used by the container
+	Memory float64 `json:"memory,omitempty"`
+}
+
+// StatsHandler receives the usage samples streamed from an engine
+type StatsHandler interface {
+	HandleStats(*ContainerStats) error
+}
+
+// UsageMonitor reports the live resource usage of engines
+type UsageMonitor interface {
+	// Usage returns the cpus and memory in MB currently used on the engine
+	Usage(*Engine) (cpus float64, memory float64)
+}
+
+// dockerStats is the subset of docker's stats api that citadel uses
+type dockerStats struct {
+	Read     time.Time `json:"read"`
+	CpuStats struct {
+		CpuUsage struct {
+			TotalUsage  uint64   `json:"total_usage"`
+			PercpuUsage []uint64 `json:"percpu_usage"`
+		} `json:"cpu_usage"`
+		SystemUsage uint64 `json:"system_cpu_usage"`
+		OnlineCpus  uint64 `json:"online_cpus"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64 `json:"usage"`
+	} `json:"memory_stats"`
+}
+
+// Stats streams usage samples for the container to the handler.  It blocks until the
+// stream is closed by docker, the handler returns an error, or stop is closed.
+func (e *Engine) Stats(c *Container, h StatsHandler, stop <-chan struct{}) error {
+	resp, err := e.request("GET", fmt.Sprintf("/containers/%s/stats", c.ID), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-stop:
+			resp.Body.Close()
+		case <-done:
+		}
+	}()
+
+	var (
+		previous *dockerStats
+		dec      = json.NewDecoder(resp.Body)
+	)
+
+	for {
+		var s *dockerStats
+		if err := dec.Decode(&s); err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		// cpu usage is reported as a counter so we need two samples to compute a rate
+		if previous != nil {
+			sample := &ContainerStats{
+				Container: c,
+				Time:      s.Read,
+				Cpus:      cpusUsed(previous, s),
+				Memory:    float64(s.MemoryStats.Usage) / 1024 / 1024,
+			}
+
+			if err := h.HandleStats(sample); err != nil {
+				return err
+			}
+		}
+
+		previous = s
+	}
+}
+
+// cpusUsed returns the number of cpus that were used between the two samples
+func cpusUsed(previous, current *dockerStats) float64 {
+	var (
+		cpuDelta    = float64(current.CpuStats.CpuUsage.TotalUsage) - float64(previous.CpuStats.CpuUsage.TotalUsage)
+		systemDelta = float64(current.CpuStats.SystemUsage) - float64(previous.CpuStats.SystemUsage)
+	)
+
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+
+	// percpu_usage is empty on cgroup v2 so it is only used by older engines without online_cpus
+	cpus := float64(current.CpuStats.OnlineCpus)
+	if cpus == 0 {
+		cpus = float64(len(current.CpuStats.CpuUsage.PercpuUsage))
+	}
+
+	return cpuDelta / systemDelta * cpus
+}
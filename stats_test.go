@@ -0,0 +1,26 @@
+package citadel
+
+import "testing"
+
+func TestCpusUsed(t *testing.T) {
+	var previous, current dockerStats
+
+	previous.CpuStats.CpuUsage.TotalUsage = 100
+	previous.CpuStats.SystemUsage = 1000
+	current.CpuStats.CpuUsage.TotalUsage = 200
+	current.CpuStats.SystemUsage = 2000
+
+	// cgroup v2 engines report online_cpus without percpu_usage
+	current.CpuStats.OnlineCpus = 4
+
+	if cpus := cpusUsed(&previous, &current); cpus != 0.4 {
+		t.Fatalf("expected 0.4 cpus received %f", cpus)
+	}
+
+	current.CpuStats.OnlineCpus = 0
+	current.CpuStats.CpuUsage.PercpuUsage = []uint64{50, 50}
+
+	if cpus := cpusUsed(&previous, &current); cpus != 0.2 {
+		t.Fatalf("expected 0.2 cpus received %f", cpus)
+	}
+}
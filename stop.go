@@ -0,0 +1,131 @@
+package citadel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultStopSignal is sent to stop containers whose image does not set a stop signal
+	DefaultStopSignal = "SIGTERM"
+
+	// DefaultStopTimeout is the number of seconds a container has to exit after the stop
+	// signal when its image does not set a timeout
+	DefaultStopTimeout = 8
+)
+
+// stopSignal returns the signal used to stop containers of the image
+func (i *Image) stopSignal() string {
+	if i == nil || i.StopSignal == "" {
+		return DefaultStopSignal
+	}
+
+	return i.StopSignal
+}
+
+// stopTimeout returns the grace period for containers of the image to exit
+func (i *Image) stopTimeout() time.Duration {
+	if i == nil || i.StopTimeout <= 0 {
+		return DefaultStopTimeout * time.Second
+	}
+
+	return time.Duration(i.StopTimeout) * time.Second
+}
+
+// Signal sends the signal, either a name like SIGHUP or a number, to the container
+func (e *Engine) Signal(container *Container, signal string) error {
+	query := url.Values{}
+	if signal != "" {
+		query.Set("signal", signal)
+	}
+
+	resp, err := e.request("POST", fmt.Sprintf("/containers/%s/kill", container.ID), query, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// GracefulStop sends the image's stop signal to the container and kills it if it has not
+// exited after the image's stop timeout.  It returns the signal that ended the container.
+func (e *Engine) GracefulStop(container *Container) (string, error) {
+	signal := container.Image.stopSignal()
+
+	if err := e.Signal(container, signal); err != nil {
+		return "", err
+	}
+
+	exited, err := e.waitExit(container, container.Image.stopTimeout())
+	if err != nil {
+		return "", err
+	}
+
+	if exited {
+		return signal, nil
+	}
+
+	if err := e.Signal(container, "SIGKILL"); err != nil {
+		// the container exited and was removed after the last check
+		if err == ErrNotFound {
+			return signal, nil
+		}
+
+		return "", err
+	}
+
+	return "SIGKILL", nil
+}
+
+// waitExit returns true if the container exits before the timeout.  A container that no
+// longer exists, removed by docker on exit for example, has exited.
+func (e *Engine) waitExit(container *Container, timeout time.Duration) (bool, error) {
+	req, err := e.newRequest("POST", fmt.Sprintf("/containers/%s/wait", container.ID), nil, nil)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := e.do(req.WithContext(ctx))
+	if err != nil {
+		if err == ErrNotFound {
+			return true, nil
+		}
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return false, nil
+		}
+
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	// newer engines send the headers right away and the status once the container exits
+	var status struct {
+		StatusCode int
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func signalName(sig int) string {
+	if sig <= 0 {
+		return ""
+	}
+
+	return strconv.Itoa(sig)
+}
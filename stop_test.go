@@ -0,0 +1,91 @@
+package citadel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/samalba/dockerclient"
+)
+
+func TestGracefulStopRemovedContainer(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/containers/1/kill", func(w http.ResponseWriter, r *http.Request) {
+		if s := r.URL.Query().Get("signal"); s != "SIGTERM" {
+			t.Errorf("expected signal SIGTERM received %s", s)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// the container was started with auto remove and is gone once it exits
+	mux.HandleFunc("/containers/1/wait", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Engine{ID: "test", Addr: server.URL}
+	e.SetClient(&dockerclient.DockerClient{URL: u, HTTPClient: http.DefaultClient})
+
+	signal, err := e.GracefulStop(&Container{ID: "1", Image: &Image{Name: "busybox"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signal != "SIGTERM" {
+		t.Fatalf("expected the container to exit on SIGTERM received %s", signal)
+	}
+}
+
+func TestGracefulStopEscalatesToKill(t *testing.T) {
+	var (
+		mux     = http.NewServeMux()
+		signals = make(chan string, 2)
+	)
+
+	mux.HandleFunc("/containers/1/kill", func(w http.ResponseWriter, r *http.Request) {
+		signals <- r.URL.Query().Get("signal")
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// the container ignores the stop signal and never exits
+	mux.HandleFunc("/containers/1/wait", func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Engine{ID: "test", Addr: server.URL}
+	e.SetClient(&dockerclient.DockerClient{URL: u, HTTPClient: http.DefaultClient})
+
+	signal, err := e.GracefulStop(&Container{ID: "1", Image: &Image{Name: "busybox", StopSignal: "SIGINT", StopTimeout: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signal != "SIGKILL" {
+		t.Fatalf("expected the container to be killed received %s", signal)
+	}
+
+	if first, second := <-signals, <-signals; first != "SIGINT" || second != "SIGKILL" {
+		t.Fatalf("expected SIGINT then SIGKILL received %s then %s", first, second)
+	}
+}
@@ -0,0 +1,214 @@
+package citadel
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/samalba/dockerclient"
+)
+
+// modes for delivering an image's user data to its containers
+const (
+	// UserDataLabels stores each key as a citadel.user-data.<key> label on the container
+	UserDataLabels = "labels"
+
+	// UserDataFiles writes each key as a file into a volume mounted at UserDataPath
+	UserDataFiles = "files"
+
+	// UserDataMetadata serves the user data from a metadata endpoint queried by container id
+	UserDataMetadata = "metadata"
+
+	// UserDataPath is where the user data volume is mounted in UserDataFiles mode
+	UserDataPath = "/citadel/user-data"
+
+	labelUserDataPrefix = "citadel.user-data."
+
+	// userDataEnv is the env var pointing the container at UserDataPath in UserDataFiles mode
+	userDataEnv = "CITADEL_USER_DATA"
+
+	// userDataFile is the file in the container's root holding the user data in UserDataMetadata mode
+	userDataFile = ".citadel-user-data.json"
+)
+
+// UserDataMode returns the mode used to deliver the image's user data
+func (i *Image) UserDataMode() string {
+	if i.UserDataDelivery == "" {
+		return UserDataLabels
+	}
+
+	return i.UserDataDelivery
+}
+
+// applyUserData adds the user data of the image to the container's config for the modes
+// that are set up before the container is created
+func applyUserData(i *Image, config *dockerclient.ContainerConfig) error {
+	if len(i.UserData) == 0 {
+		return nil
+	}
+
+	switch i.UserDataMode() {
+	case UserDataLabels:
+		for k, v := range i.UserData {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+
+			config.Labels[labelUserDataPrefix+k] = string(data)
+		}
+	case UserDataFiles:
+		for k := range i.UserData {
+			if k == "" || strings.Contains(k, "/") || k == "." || k == ".." {
+				return fmt.Errorf("invalid user data key %q", k)
+			}
+		}
+
+		config.Volumes[UserDataPath] = struct{}{}
+		config.Env = append(config.Env, fmt.Sprintf("%s=%s", userDataEnv, UserDataPath))
+	case UserDataMetadata:
+	default:
+		return fmt.Errorf("unknown user data mode %s", i.UserDataDelivery)
+	}
+
+	return nil
+}
+
+// copyUserData writes the user data into the created container.  In UserDataFiles mode
+// each key is a file in the user data volume with a value on each line and in
+// UserDataMetadata mode the user data is kept as json in the container's root.
+func (e *Engine) copyUserData(c *Container) error {
+	var (
+		i     = c.Image
+		path  string
+		files = make(map[string][]byte)
+	)
+
+	if len(i.UserData) == 0 {
+		return nil
+	}
+
+	switch i.UserDataMode() {
+	case UserDataFiles:
+		path = UserDataPath
+
+		for k, v := range i.UserData {
+			files[k] = []byte(strings.Join(v, "\n") + "\n")
+		}
+	case UserDataMetadata:
+		path = "/"
+
+		data, err := json.Marshal(i.UserData)
+		if err != nil {
+			return err
+		}
+
+		files[userDataFile] = data
+	default:
+		return nil
+	}
+
+	var (
+		buf = bytes.NewBuffer(nil)
+		tw  = tar.NewWriter(buf)
+	)
+
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0444,
+			Size: int64(len(data)),
+		}); err != nil {
+			return err
+		}
+
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	req, err := e.newRequest("PUT", fmt.Sprintf("/containers/%s/archive", c.ID), url.Values{"path": {path}}, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	resp, err := e.do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// UserData returns the user data delivered to the container.  The user data of the files
+// and metadata modes is not stored in the container's labels so it is read back from the
+// container.
+func (e *Engine) UserData(c *Container) (map[string][]string, error) {
+	switch c.Image.UserDataMode() {
+	case UserDataFiles:
+		return e.readUserData(c, UserDataPath)
+	case UserDataMetadata:
+		return e.readUserData(c, "/"+userDataFile)
+	}
+
+	return c.Image.UserData, nil
+}
+
+// readUserData reads the user data files, or the json user data file, at the path in the container
+func (e *Engine) readUserData(c *Container, path string) (map[string][]string, error) {
+	resp, err := e.request("GET", fmt.Sprintf("/containers/%s/archive", c.ID), url.Values{"path": {path}}, nil)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var (
+		data = make(map[string][]string)
+		tr   = tar.NewReader(resp.Body)
+	)
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return data, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if filepath.Base(h.Name) == userDataFile {
+			if err := json.NewDecoder(tr).Decode(&data); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		raw, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		data[filepath.Base(h.Name)] = strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+	}
+}
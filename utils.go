@@ -7,13 +7,6 @@ import (
 	"github.com/samalba/dockerclient"
 )
 
-type (
-	ImageInfo struct {
-		Name string
-		Tag  string
-	}
-)
-
 func parsePortInformation(info *dockerclient.ContainerInfo, c *Container) error {
 	for pp, b := range info.NetworkSettings.Ports {
 		parts := strings.Split(pp, "/")
@@ -71,6 +64,7 @@ func parsePortInformation(info *dockerclient.ContainerInfo, c *Container) error
 	return nil
 }
 
+// FromDockerContainer returns the container with the image spec it was created from
 func FromDockerContainer(id, image string, engine *Engine) (*Container, error) {
 	info, err := engine.client.InspectContainer(id)
 	if err != nil {
@@ -78,29 +72,11 @@ func FromDockerContainer(id, image string, engine *Engine) (*Container, error) {
 	}
 
 	var (
-		cType       = ""
 		state       = "stopped"
 		networkMode = "bridge"
-		labels      = []string{}
-		env         = make(map[string]string)
+		meta, env   = parseMetadata(info.Config)
 	)
 
-	for _, e := range info.Config.Env {
-		vals := strings.Split(e, "=")
-		k, v := vals[0], vals[1]
-
-		switch k {
-		case "_citadel_type":
-			cType = v
-		case "_citadel_labels":
-			labels = strings.Split(v, ",")
-		case "HOME", "DEBIAN_FRONTEND", "PATH":
-			continue
-		default:
-			env[k] = v
-		}
-	}
-
 	if info.State.Running {
 		state = "running"
 	}
@@ -109,21 +85,44 @@ func FromDockerContainer(id, image string, engine *Engine) (*Container, error) {
 		networkMode = m
 	}
 
+	memorySwap := float64(info.Config.MemorySwap / 1024 / 1024)
+	if info.Config.MemorySwap < 0 {
+		memorySwap = -1
+	}
+
 	container := &Container{
-		ID:     id,
-		Engine: engine,
-		Name:   info.Name,
-		State:  state,
+		ID:         id,
+		ImageID:    info.Image,
+		Engine:     engine,
+		Name:       info.Name,
+		State:      state,
+		ExitCode:   info.State.ExitCode,
+		StartedAt:  info.State.StartedAt,
+		FinishedAt: info.State.FinishedAt,
+		OOMKilled:  info.State.OOMKilled,
 		Image: &Image{
 			Name:        image,
 			Cpus:        float64(info.Config.CpuShares) / 100.0 * engine.Cpus,
 			Memory:      float64(info.Config.Memory / 1024 / 1024),
+			MemorySwap:  memorySwap,
+			Cpuset:      info.Config.Cpuset,
+			Disk:        meta.Disk,
 			Environment: env,
 			Hostname:    info.Config.Hostname,
 			Domainname:  info.Config.Domainname,
-			Type:        cType,
-			Labels:      labels,
+			Entrypoint:  info.Config.Entrypoint,
+			WorkingDir:  info.Config.WorkingDir,
+			User:        info.Config.User,
+			Type:        meta.Type,
+			Labels:      meta.Labels,
+			Owner:       meta.Owner,
+			Service:     meta.Service,
 			NetworkMode: networkMode,
+			Privileged:  info.HostConfig.Privileged,
+			CapAdd:      info.HostConfig.CapAdd,
+			CapDrop:     info.HostConfig.CapDrop,
+			Dns:         info.HostConfig.Dns,
+			ExtraHosts:  info.HostConfig.ExtraHosts,
 			RestartPolicy: RestartPolicy{
 				Name:              info.HostConfig.RestartPolicy.Name,
 				MaximumRetryCount: info.HostConfig.RestartPolicy.MaximumRetryCount,
@@ -131,6 +130,42 @@ func FromDockerContainer(id, image string, engine *Engine) (*Container, error) {
 		},
 	}
 
+	for _, u := range info.HostConfig.Ulimits {
+		container.Image.Ulimits = append(container.Image.Ulimits, &Ulimit{
+			Name: u.Name,
+			Soft: u.Soft,
+			Hard: u.Hard,
+		})
+	}
+
+	for _, d := range info.HostConfig.Devices {
+		container.Image.Devices = append(container.Image.Devices, &Device{
+			PathOnHost:        d.PathOnHost,
+			PathInContainer:   d.PathInContainer,
+			CgroupPermissions: d.CgroupPermissions,
+		})
+	}
+
+	if lc := info.HostConfig.LogConfig; lc.Type != "" {
+		container.Image.LogConfig = &LogConfig{
+			Type:   lc.Type,
+			Config: lc.Config,
+		}
+	}
+
+	// containers created by citadel carry their original spec, the inferred image is
+	// only used for containers that were created outside of citadel
+	if meta.Image != nil {
+		container.Image = meta.Image
+	} else {
+		imageVolumes := map[string]bool{}
+		if len(info.Config.Volumes) > 0 {
+			imageVolumes = engine.imageVolumes(info.Image)
+		}
+
+		container.Image.Volumes = parseVolumes(info, imageVolumes)
+	}
+
 	if err := parsePortInformation(info, container); err != nil {
 		return nil, err
 	}
@@ -138,17 +173,26 @@ func FromDockerContainer(id, image string, engine *Engine) (*Container, error) {
 	return container, nil
 }
 
-func parseImageName(name string) *ImageInfo {
-	imageInfo := &ImageInfo{
-		Name: name,
-		Tag:  "latest",
+// parseVolumes returns the volumes requested for the container, the volumes declared by
+// the image's Dockerfile are skipped
+func parseVolumes(info *dockerclient.ContainerInfo, imageVolumes map[string]bool) []*Volume {
+	var (
+		out   = []*Volume{}
+		bound = make(map[string]bool)
+	)
+
+	for _, b := range info.HostConfig.Binds {
+		v := parseBind(b)
+
+		bound[v.ContainerPath] = true
+		out = append(out, v)
 	}
 
-	img := strings.Split(name, ":")
-	if len(img) == 2 {
-		imageInfo.Name = img[0]
-		imageInfo.Tag = img[1]
+	for path := range info.Config.Volumes {
+		if !bound[path] && !imageVolumes[path] {
+			out = append(out, &Volume{ContainerPath: path})
+		}
 	}
 
-	return imageInfo
+	return out
 }
@@ -0,0 +1,136 @@
+package citadel
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Volume is a bind mount or docker volume that is mounted into the container
+type Volume struct {
+	// Name is the name of a docker volume.  Containers using a named volume are placed
+	// on the engine that already holds the volume
+	Name string `json:"name,omitempty"`
+
+	// HostPath is the path on the engine that is bind mounted into the container
+	HostPath string `json:"host_path,omitempty"`
+
+	// ContainerPath is the path inside the container where the volume is mounted
+	ContainerPath string `json:"container_path,omitempty"`
+
+	// ReadOnly mounts the volume read only
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+// bind returns the docker bind string for the volume or an empty string if the volume
+// is an anonymous volume
+func (v *Volume) bind() string {
+	source := v.HostPath
+	if v.Name != "" {
+		source = v.Name
+	}
+
+	if source == "" {
+		return ""
+	}
+
+	b := fmt.Sprintf("%s:%s", source, v.ContainerPath)
+	if v.ReadOnly {
+		b += ":ro"
+	}
+
+	return b
+}
+
+func (v *Volume) String() string {
+	if b := v.bind(); b != "" {
+		return b
+	}
+
+	return v.ContainerPath
+}
+
+// parseBind converts a docker bind string back into a volume
+func parseBind(b string) *Volume {
+	var (
+		v     = &Volume{}
+		parts = strings.Split(b, ":")
+	)
+
+	switch len(parts) {
+	case 1:
+		v.ContainerPath = parts[0]
+		return v
+	case 3:
+		v.ReadOnly = parts[2] == "ro"
+	}
+
+	if strings.HasPrefix(parts[0], "/") {
+		v.HostPath = parts[0]
+	} else {
+		v.Name = parts[0]
+	}
+
+	v.ContainerPath = parts[1]
+
+	return v
+}
+
+// ListVolumes returns the names of the docker volumes on the engine
+func (e *Engine) ListVolumes() ([]string, error) {
+	resp, err := e.request("GET", "/volumes", nil, nil)
+	if err != nil {
+		// engines older than the volume api do not have named volumes
+		if err == ErrNotFound {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Volumes []struct {
+			Name string
+		}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	out := []string{}
+	for _, v := range list.Volumes {
+		out = append(out, v.Name)
+	}
+
+	return out, nil
+}
+
+// imageVolumes returns the volumes declared by the image's Dockerfile, none if the image
+// cannot be inspected
+func (e *Engine) imageVolumes(image string) map[string]bool {
+	volumes := make(map[string]bool)
+
+	resp, err := e.request("GET", fmt.Sprintf("/images/%s/json", image), nil, nil)
+	if err != nil {
+		return volumes
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Config struct {
+			Volumes map[string]struct{}
+		}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return volumes
+	}
+
+	for path := range info.Config.Volumes {
+		volumes[path] = true
+	}
+
+	return volumes
+}
@@ -0,0 +1,248 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/citadel/citadel"
+)
+
+// errPermanent is returned for responses that will not succeed when the batch is retried
+type errPermanent struct {
+	status string
+}
+
+func (e *errPermanent) Error() string {
+	return fmt.Sprintf("endpoint rejected events: %s", e.status)
+}
+
+type endpoint struct {
+	*Endpoint
+
+	options Options
+	queue   chan *citadel.Event
+	done    chan struct{}
+
+	// stop is closed when the handler is closing to interrupt the backoff between retries
+	stop chan struct{}
+
+	// spool is the directory of batches waiting for the endpoint to be available
+	spool string
+}
+
+func newEndpoint(e *Endpoint, options Options) (*endpoint, error) {
+	if e.URL == "" {
+		return nil, fmt.Errorf("webhook endpoint has no url")
+	}
+
+	ep := &endpoint{
+		Endpoint: e,
+		options:  options,
+		queue:    make(chan *citadel.Event, options.QueueSize),
+		done:     make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+
+	if options.SpoolDir != "" {
+		sum := sha256.Sum256([]byte(e.URL))
+		ep.spool = filepath.Join(options.SpoolDir, hex.EncodeToString(sum[:8]))
+
+		if err := os.MkdirAll(ep.spool, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	return ep, nil
+}
+
+// run batches the queued events and posts them until the queue is closed
+func (ep *endpoint) run() {
+	defer close(ep.done)
+
+	var (
+		batch  = []*citadel.Event{}
+		ticker = time.NewTicker(ep.options.FlushInterval)
+	)
+	defer ticker.Stop()
+
+	flush := func(closing bool) {
+		if len(batch) > 0 {
+			ep.deliver(batch, closing)
+			batch = []*citadel.Event{}
+		}
+	}
+
+	for {
+		select {
+		case e, ok := <-ep.queue:
+			if !ok {
+				flush(true)
+				return
+			}
+
+			batch = append(batch, e)
+
+			if len(batch) >= ep.options.BatchSize {
+				flush(false)
+			}
+		case <-ticker.C:
+			flush(false)
+			ep.resendSpooled()
+		}
+	}
+}
+
+// deliver posts the batch retrying with a backoff and spools it if it cannot be posted.
+// Only one attempt is made when the handler is closing and retries stop when it closes.
+func (ep *endpoint) deliver(batch []*citadel.Event, closing bool) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		log.Printf("webhook: unable to encode events for %s: %s\n", ep.URL, err)
+		return
+	}
+
+	// spooled batches are posted first to keep the events in order
+	if ep.hasSpooled() {
+		ep.spoolBatch(body)
+		return
+	}
+
+	var (
+		attempts = ep.options.Attempts
+		backoff  = ep.options.Backoff
+	)
+
+	if closing {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err = ep.post(body); err == nil {
+			return
+		}
+
+		if _, ok := err.(*errPermanent); ok {
+			log.Printf("webhook: dropping %d events for %s: %s\n", len(batch), ep.URL, err)
+			return
+		}
+
+		if attempt >= attempts || !ep.wait(backoff) {
+			break
+		}
+
+		if backoff *= 2; backoff > ep.options.MaxBackoff {
+			backoff = ep.options.MaxBackoff
+		}
+	}
+
+	log.Printf("webhook: unable to post %d events to %s: %s\n", len(batch), ep.URL, err)
+
+	ep.spoolBatch(body)
+}
+
+// wait returns false if the handler is closed before the backoff has passed
+func (ep *endpoint) wait(backoff time.Duration) bool {
+	select {
+	case <-time.After(backoff):
+		return true
+	case <-ep.stop:
+		return false
+	}
+}
+
+// post sends the body to the endpoint signed with the endpoint's secret
+func (ep *endpoint) post(body []byte) error {
+	req, err := http.NewRequest("POST", ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if ep.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(ep.Secret, body))
+	}
+
+	resp, err := ep.options.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	default:
+		return &errPermanent{status: resp.Status}
+	}
+}
+
+func (ep *endpoint) spoolBatch(body []byte) {
+	if ep.spool == "" {
+		log.Printf("webhook: dropping events for %s\n", ep.URL)
+		return
+	}
+
+	path := filepath.Join(ep.spool, fmt.Sprintf("%020d.json", time.Now().UnixNano()))
+
+	if err := ioutil.WriteFile(path, body, 0600); err != nil {
+		log.Printf("webhook: unable to spool events for %s: %s\n", ep.URL, err)
+	}
+}
+
+// spooled returns the paths of the spooled batches, oldest first
+func (ep *endpoint) spooled() []string {
+	if ep.spool == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(ep.spool, "*.json"))
+	if err != nil {
+		log.Printf("webhook: unable to read spool for %s: %s\n", ep.URL, err)
+		return nil
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+func (ep *endpoint) hasSpooled() bool {
+	return len(ep.spooled()) > 0
+}
+
+// resendSpooled posts the spooled batches in order, it stops at the first failure
+func (ep *endpoint) resendSpooled() {
+	for _, path := range ep.spooled() {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("webhook: unable to read spooled events %s: %s\n", path, err)
+			return
+		}
+
+		if err := ep.post(body); err != nil {
+			if _, ok := err.(*errPermanent); !ok {
+				return
+			}
+
+			log.Printf("webhook: dropping spooled events %s for %s: %s\n", path, ep.URL, err)
+		}
+
+		if err := os.Remove(path); err != nil {
+			log.Printf("webhook: unable to remove spooled events %s: %s\n", path, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/citadel/citadel"
+	"github.com/citadel/citadel/eventbus"
+)
+
+const (
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = time.Second
+	DefaultQueueSize     = 1024
+	DefaultAttempts      = 5
+	DefaultBackoff       = 500 * time.Millisecond
+	DefaultMaxBackoff    = 30 * time.Second
+	DefaultClientTimeout = 30 * time.Second
+)
+
+// Endpoint is a url that batches of events are posted to
+type Endpoint struct {
+	URL string `json:"url,omitempty"`
+
+	// Secret is the key used to sign the batches, they are not signed when it is empty
+	Secret string `json:"secret,omitempty"`
+
+	// Filter selects the events posted to the endpoint, all events when it is nil
+	Filter *eventbus.Filter `json:"filter,omitempty"`
+}
+
+// Options control the batching and retries of the posts to the endpoints
+type Options struct {
+	// BatchSize is the maximum number of events posted at once
+	BatchSize int
+
+	// FlushInterval is how long events wait for a batch to fill before they are posted
+	FlushInterval time.Duration
+
+	// QueueSize is the number of events queued for each endpoint
+	QueueSize int
+
+	// Attempts is the number of times a batch is posted before it is spooled
+	Attempts int
+
+	// Backoff is the wait after the first failed post, it doubles for each attempt up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// SpoolDir is where batches that could not be posted are stored until the endpoint
+	// is available again, they are dropped when it is empty
+	SpoolDir string
+
+	// Client is the http client used to post the batches, the default client times out
+	// after DefaultClientTimeout
+	Client *http.Client
+}
+
+func (o *Options) setDefaults() {
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = DefaultFlushInterval
+	}
+
+	if o.QueueSize <= 0 {
+		o.QueueSize = DefaultQueueSize
+	}
+
+	if o.Attempts <= 0 {
+		o.Attempts = DefaultAttempts
+	}
+
+	if o.Backoff <= 0 {
+		o.Backoff = DefaultBackoff
+	}
+
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = DefaultMaxBackoff
+	}
+
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: DefaultClientTimeout}
+	}
+}
+
+// Handler is an EventHandler that posts batches of json events to endpoints
+type Handler struct {
+	mux       sync.Mutex
+	endpoints []*endpoint
+	states    *eventbus.States
+	closed    bool
+}
+
+// New returns a handler posting events to the endpoints
+func New(endpoints []*Endpoint, options Options) (*Handler, error) {
+	options.setDefaults()
+
+	h := &Handler{
+		states: eventbus.NewStates(),
+	}
+
+	for _, e := range endpoints {
+		ep, err := newEndpoint(e, options)
+		if err != nil {
+			return nil, err
+		}
+
+		h.endpoints = append(h.endpoints, ep)
+	}
+
+	for _, ep := range h.endpoints {
+		go ep.run()
+	}
+
+	return h, nil
+}
+
+// Handle queues the event for the endpoints whose filter matches it.  An error is returned
+// if the queue of an endpoint is full.
+func (h *Handler) Handle(e *citadel.Event) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if h.closed {
+		return fmt.Errorf("webhook handler is closed")
+	}
+
+	var (
+		err      error
+		previous = h.states.Transition(e)
+		record   = e.Record()
+	)
+
+	for _, ep := range h.endpoints {
+		if ep.Filter != nil && !ep.Filter.Match(e, previous) {
+			continue
+		}
+
+		select {
+		case ep.queue <- record:
+		default:
+			err = fmt.Errorf("webhook queue for %s is full", ep.URL)
+		}
+	}
+
+	return err
+}
+
+// Close stops retrying failed posts, posts the queued events once and stops the endpoints
+func (h *Handler) Close() error {
+	h.mux.Lock()
+	if h.closed {
+		h.mux.Unlock()
+		return nil
+	}
+	h.closed = true
+	h.mux.Unlock()
+
+	for _, ep := range h.endpoints {
+		close(ep.stop)
+		close(ep.queue)
+	}
+
+	for _, ep := range h.endpoints {
+		<-ep.done
+	}
+
+	return nil
+}
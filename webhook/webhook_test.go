@@ -0,0 +1,227 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/citadel/citadel"
+	"github.com/citadel/citadel/eventbus"
+)
+
+type receiver struct {
+	mux     sync.Mutex
+	secret  string
+	fail    bool
+	events  []*citadel.Event
+	batches int
+	invalid int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if r.secret != "" && !Verify(r.secret, body, req.Header.Get(SignatureHeader)) {
+		r.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var batch []*citadel.Event
+	if err := json.Unmarshal(body, &batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.batches++
+	r.events = append(r.events, batch...)
+}
+
+func (r *receiver) setFail(fail bool) {
+	r.mux.Lock()
+	r.fail = fail
+	r.mux.Unlock()
+}
+
+func (r *receiver) received() ([]*citadel.Event, int, int) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return append([]*citadel.Event{}, r.events...), r.batches, r.invalid
+}
+
+func (r *receiver) waitFor(t *testing.T, n int) []*citadel.Event {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if events, _, _ := r.received(); len(events) >= n {
+			return events
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events, _, _ := r.received()
+	t.Fatalf("expected %d events received %d", n, len(events))
+
+	return nil
+}
+
+func event(tpe citadel.EventType) *citadel.Event {
+	return &citadel.Event{
+		Type: tpe,
+		Time: time.Now(),
+		Engine: &citadel.Engine{
+			ID:           "local",
+			RegistryAuth: []*citadel.RegistryAuth{{Username: "user", Password: "secret"}},
+		},
+	}
+}
+
+func TestHandlerPostsSignedFilteredBatches(t *testing.T) {
+	r := &receiver{secret: "key"}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	h, err := New([]*Endpoint{
+		{
+			URL:    server.URL,
+			Secret: "key",
+			Filter: &eventbus.Filter{Types: []citadel.EventType{citadel.EventDie}},
+		},
+	}, Options{BatchSize: 2, FlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for _, tpe := range []citadel.EventType{citadel.EventStart, citadel.EventDie, citadel.EventDie, citadel.EventStop} {
+		if err := h.Handle(event(tpe)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events := r.waitFor(t, 2)
+
+	if _, batches, invalid := r.received(); batches != 1 || invalid != 0 {
+		t.Fatalf("expected 1 valid batch received %d with %d invalid", batches, invalid)
+	}
+
+	for _, e := range events {
+		if e.Type != citadel.EventDie {
+			t.Fatalf("expected only die events received %s", e.Type)
+		}
+
+		if len(e.Engine.RegistryAuth) != 0 {
+			t.Fatal("expected engine credentials not to be posted")
+		}
+	}
+}
+
+func TestHandlerSpoolsDuringOutage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &receiver{fail: true}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	h, err := New([]*Endpoint{{URL: server.URL}}, Options{
+		BatchSize:     1,
+		FlushInterval: 10 * time.Millisecond,
+		Attempts:      2,
+		Backoff:       time.Millisecond,
+		SpoolDir:      dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for _, tpe := range []citadel.EventType{citadel.EventStart, citadel.EventDie} {
+		if err := h.Handle(event(tpe)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// wait for both batches to be spooled before the endpoint recovers
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.endpoints[0].spooled()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the events to be spooled")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r.setFail(false)
+
+	events := r.waitFor(t, 2)
+
+	if events[0].Type != citadel.EventStart || events[1].Type != citadel.EventDie {
+		t.Fatalf("expected spooled events in order received %s and %s", events[0].Type, events[1].Type)
+	}
+}
+
+func TestCloseInterruptsBackoff(t *testing.T) {
+	r := &receiver{fail: true}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	h, err := New([]*Endpoint{{URL: server.URL}}, Options{
+		BatchSize: 1,
+		Attempts:  3,
+		Backoff:   time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Handle(event(citadel.EventDie)); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the first post to fail so that the endpoint is in its backoff
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		h.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected close to interrupt the backoff")
+	}
+}
+
+func TestDefaultClientTimeout(t *testing.T) {
+	var o Options
+	o.setDefaults()
+
+	if o.Client.Timeout != DefaultClientTimeout {
+		t.Fatalf("expected a client timeout of %s received %s", DefaultClientTimeout, o.Client.Timeout)
+	}
+}